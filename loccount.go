@@ -2,21 +2,35 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 const version string = "1.2"
@@ -32,10 +46,13 @@ languages fall into one of the following groups:
   extension only.  These languages have two kinds of comment.  One is
   a block comment delimited by two distinct strings and the second is
   a winged comment introduced by a third string and terminated by
-  newline.  The following bool signals whether newlines are permitted
-  in strings.  You can add support simply by appending an initializer
-  to the genericLanguages table; any entry with a nonempty comment
-  leader invokes C-like parsing.
+  newline.  The multistring field, if nonempty, is the delimiter of
+  a multiline string literal (a backtick in Go, a triple quote in
+  Scala).  The following bool signals whether newlines are permitted
+  in strings, and the one after that whether block comments nest.
+  You can add support simply by appending an initializer to the
+  genericLanguages table; any entry with a nonempty comment leader
+  invokes C-like parsing.
 
 * Generic languages have only winged comments, usually led with #.
   This code recognizes them by file extension only.  You can append an
@@ -52,8 +69,10 @@ languages fall into one of the following groups:
 * Pascal-likes use the (* *) block comment syntax.  This code
   recognizes them by file extension only.  You can append an
   initializer to the PascalLikes table specifying a name, an
-  extension, and a boolean saying whether the language uses { } as
-  additional pair of block comments.
+  extension, a boolean saying whether the language uses { } as
+  additional pair of block comments, and a boolean saying whether
+  comments nest.  Nesting languages are assumed to be OCaml-like,
+  with double-quoted strings that are lexed even inside comments.
 
 * Fortran-likes use various start-of-line characters as comment
   leaders.  This code recognizes them by file extension only.  You can
@@ -68,8 +87,9 @@ must be unique across all tables.
 // Following code swiped from Michael T. Jones's "walk" package.
 // It's a parallelized implementation of tree-walking that's
 // faster than the version in the system filepath library.
-// Note, however, it seems to have a limitation - does not like paths
-// containing "..".
+// It used to seem to choke on roots containing "..", but that was
+// the dotfile filters taking the ".." for a hidden directory; they
+// now look past such components.
 
 type VisitData struct {
 	path string
@@ -92,14 +112,46 @@ type VisitData struct {
 type WalkFunc func(path string, info os.FileInfo, err error) error
 
 type WalkState struct {
+	ctx        context.Context
 	walkFn     WalkFunc
 	v          chan VisitData // files to be processed
 	active     sync.WaitGroup // number of files to process
 	lock       sync.RWMutex
-	firstError error // accessed using lock
+	firstError error           // accessed using lock
+	follow     bool            // descend through symlinks to directories?
+	entered    map[dirKey]bool // directories walked, when following; uses lock
+}
+
+// dirKey - identifies a directory however many paths lead to it
+type dirKey struct {
+	dev, ino uint64
+}
+
+// firstEntry - report whether a directory is being walked for the
+// first time, so one reached through several symlinks, or through a
+// symlink back up the tree, is walked only once
+func (ws *WalkState) firstEntry(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	key := dirKey{uint64(st.Dev), uint64(st.Ino)}
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if ws.entered[key] {
+		return false
+	}
+	ws.entered[key] = true
+	return true
 }
 
 func (ws *WalkState) terminated() bool {
+	select {
+	case <-ws.ctx.Done():
+		ws.setTerminated(ws.ctx.Err())
+		return true
+	default:
+	}
 	ws.lock.RLock()
 	done := ws.firstError != nil
 	ws.lock.RUnlock()
@@ -138,60 +190,80 @@ func readDirNames(dirname string) ([]string, error) {
 	return names, nil
 }
 
+// visitFile visits file and, if it is a directory, everything under it
+// that can't be handed to another worker.  Directories that find the
+// queue full are kept on a stack of our own rather than recursed into,
+// so however deep the tree goes the goroutine stack stays flat.
 func (ws *WalkState) visitFile(file VisitData) {
-	if ws.terminated() {
-		return
-	}
-
-	err := ws.walkFn(file.path, file.info, nil)
-	if err != nil {
-		if !(file.info.IsDir() && err == filepath.SkipDir) {
-			ws.setTerminated(err)
+	pending := []VisitData{file}
+	for len(pending) > 0 {
+		if ws.terminated() {
+			return
+		}
+		file = pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if ws.follow && file.info.IsDir() && !ws.firstEntry(file.info) {
+			continue
 		}
-		return
-	}
-
-	if !file.info.IsDir() {
-		return
-	}
 
-	names, err := readDirNames(file.path)
-	if err != nil {
-		err = ws.walkFn(file.path, file.info, err)
+		err := ws.walkFn(file.path, file.info, nil)
 		if err != nil {
-			ws.setTerminated(err)
+			if !(file.info.IsDir() && err == filepath.SkipDir) {
+				ws.setTerminated(err)
+			}
+			continue
 		}
-		return
-	}
 
-	here := file.path
-	for _, name := range names {
-		file.path = filepath.Join(here, name)
-		file.info, err = os.Lstat(file.path)
+		if !file.info.IsDir() {
+			continue
+		}
+
+		names, err := readDirNames(file.path)
 		if err != nil {
 			err = ws.walkFn(file.path, file.info, err)
-			if err != nil && (!file.info.IsDir() || err != filepath.SkipDir) {
+			if err != nil {
 				ws.setTerminated(err)
+			}
+			continue
+		}
+
+		here := file.path
+		for _, name := range names {
+			if ws.terminated() {
 				return
 			}
-		} else {
-			switch file.info.IsDir() {
-			case true:
-				ws.active.Add(1) // presume channel send will succeed
-				select {
-				case ws.v <- file:
-					// push directory info to queue for concurrent traversal
-				default:
-					// undo increment when send fails and handle now
-					ws.active.Add(-1)
-					ws.visitFile(file)
-				}
-			case false:
-				err = ws.walkFn(file.path, file.info, nil)
-				if err != nil {
+			file.path = filepath.Join(here, name)
+			file.info, err = os.Lstat(file.path)
+			if err == nil && ws.follow && file.info.Mode()&os.ModeSymlink != 0 {
+				if target, serr := os.Stat(file.path); serr == nil && target.IsDir() {
+					file.info = target
+				}
+			}
+			if err != nil {
+				err = ws.walkFn(file.path, file.info, err)
+				if err != nil && (!file.info.IsDir() || err != filepath.SkipDir) {
 					ws.setTerminated(err)
 					return
 				}
+			} else {
+				switch file.info.IsDir() {
+				case true:
+					ws.active.Add(1) // presume channel send will succeed
+					select {
+					case ws.v <- file:
+						// push directory info to queue for concurrent traversal
+					default:
+						// undo increment when send fails and handle later
+						ws.active.Add(-1)
+						pending = append(pending, file)
+					}
+				case false:
+					err = ws.walkFn(file.path, file.info, nil)
+					if err != nil {
+						ws.setTerminated(err)
+						return
+					}
+				}
 			}
 		}
 	}
@@ -203,14 +275,41 @@ func (ws *WalkState) visitFile(file VisitData) {
 // order. Walk does not follow symbolic links.
 
 func Walk(root string, walkFn WalkFunc) error {
+	return WalkContext(context.Background(), root, walkFn)
+}
+
+// WalkContext is like Walk, but stops early when ctx is cancelled.  The
+// workers check ctx between files, so a walk in progress winds down
+// promptly and WalkContext returns ctx.Err().
+func WalkContext(ctx context.Context, root string, walkFn WalkFunc) error {
+	return WalkWith(ctx, root, WalkOptions{}, walkFn)
+}
+
+// WalkOptions - how WalkWith goes about a walk
+type WalkOptions struct {
+	// Walk symbolic links to directories as the directories,
+	// entering each directory only once.
+	FollowSymlinks bool
+}
+
+// WalkWith is like WalkContext, but walks as the options say.
+func WalkWith(ctx context.Context, root string, opts WalkOptions, walkFn WalkFunc) error {
 	info, err := os.Lstat(root)
 	if err != nil {
 		return walkFn(root, nil, err)
 	}
+	if opts.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(root); err == nil {
+			info = target
+		}
+	}
 
 	ws := &WalkState{
-		walkFn: walkFn,
-		v:      make(chan VisitData, 1024),
+		ctx:     ctx,
+		walkFn:  walkFn,
+		v:       make(chan VisitData, 1024),
+		follow:  opts.FollowSymlinks,
+		entered: make(map[dirKey]bool),
 	}
 	defer close(ws.v)
 
@@ -232,12 +331,82 @@ func Walk(root string, walkFn WalkFunc) error {
 type SourceStat struct {
 	Path     string
 	Language string
-	SLOC     uint
+	// recognized as some language, even if it holds no code
+	Classified bool
+	SLOC       uint64
+	Data       uint64 // embedded data lines, split out only with -data
+	Preproc    uint64 // preprocessor directive lines, tallied only with -preproc
+	// all lines, code or not, tallied only with -all-lines
+	TotalLines uint64
+	// lines ending a statement, tallied only with -effective
+	Effective uint64
+	// declaration lines outside function bodies, tallied only with -api
+	API uint64
+	// string-literal lines that look like SQL, only with -embedded-sql
+	EmbeddedSQL uint64
+	// how sure a verifier was of the language, 0 if none was needed
+	Confidence float64
+	// file size in bytes and UTF-8 characters, tallied only with -chars
+	Bytes uint64
+	Runes uint64
+	// leading columns summed over code lines, tallied only with -indent
+	Indent uint64
+	// code lines that also hold a comment, only with -comment-density
+	Commented uint64
+	cplusplus bool              // a C header that looks like C++
+	objc      bool              // a C header that looks like Objective-C
+	comments  bool              // no code but some comments, for -comment-only
+	migration bool              // named like a schema migration
+	generated bool              // named like generated code
+	test      bool              // lives where tests conventionally do
+	rejected  string            // filter that turned it away, for -dry-run
+	module    string            // module or package it belongs to, for -bymodule
+	problems  []string          // unterminated comments and strings, for -strict
+	blame     map[string]uint64 // code lines by last author, for -blame
+	// headed as generated, and so turned away by the filter for that
+	autogenerated bool
 }
 
 var debug int
+var fortranLogical bool
+var iniSections bool
+var noCountBraces bool
+var noCountRegions bool
+var countXML bool
+var countTeX bool
+var relativeBase string
+var splitData bool
+var countPreproc bool
+var allLines bool
+var countChars bool
+var docstringsAsCode bool
+var blaming bool
+var countEffective bool
+var countAPI bool
+var gunzip bool
+var findSQL bool
+var indenting bool
+var followSymlinks bool
+var byModule bool
+var commentDensity bool
+var tabWidths map[string]int // by language; "" is the default
 var exclusions []string
+var extOnly []string
+var migrationPatterns []string
+var generatedPatterns []string
+var countGenerated bool
+var splitTests bool
+var dryRun bool
+var ksloc bool
+var commentOnly bool
+var countEmpty bool
+var langStats bool
+var thousands bool
+var skipTestdata bool
+var embedded map[string]bool
 var pipeline chan SourceStat
+var duplicates *dupDetector
+var ambiguity *ambiguityChecker
 
 // Data tables driving the recognition and counting of classes of languages.
 
@@ -249,6 +418,7 @@ type genericLanguage struct {
 	eolcomment     string
 	multistring    string
 	eolwarn        bool
+	nestcomments   bool
 	verifier       func(*countContext, string) bool
 }
 
@@ -267,6 +437,7 @@ type pascalLike struct {
 	name            string
 	suffix          string
 	bracketcomments bool
+	nestcomments    bool
 	verifier        func(*countContext, string) bool
 }
 
@@ -279,21 +450,94 @@ var dtriple, striple, dtrailer, strailer, dlonely, slonely *regexp.Regexp
 
 var podheader *regexp.Regexp
 
+// Here-document introducer, as in Ruby and HCL
+var heredocStart *regexp.Regexp
+
+// What follows the # of an editor folding marker
+var regionMarker *regexp.Regexp
+
+// Lines that are commands to an SQL client rather than SQL: the GO
+// batch separator of T-SQL tools and MySQL's DELIMITER
+var sqlClientCommand *regexp.Regexp
+
+// Declarations that name a module, for -bymodule
+var goModuleDecl, javaPackageDecl *regexp.Regexp
+
+// What declarations look like, for -api
+var apiTypeLine, apiScopeOpener, apiCallLike, apiControl, pythonDecl *regexp.Regexp
+
+// Start of a LaTeX environment in which % is not a comment
+var texVerbatim *regexp.Regexp
+
+// Opening <script> tags in components, and the attribute making one TypeScript
+var scriptTag, scriptTS *regexp.Regexp
+
+// String literals that look like SQL, for -embedded-sql
+var sqlStart *regexp.Regexp
+
 type fortranLike struct {
 	name      string
 	suffix    string
 	comment   *regexp.Regexp
 	nocomment *regexp.Regexp
+	fixedform bool
 }
 
 var fortranLikes []fortranLike
 
+// LanguageTables - the rule sets that drive recognition and counting.
+// The package-level tables are the defaults; CountWith runs a count
+// under some other set.
+type LanguageTables struct {
+	Generic      []genericLanguage
+	Scripting    []scriptingLanguage
+	PascalLikes  []pascalLike
+	FortranLikes []fortranLike
+	// Quirks of the C-family counter, by language name
+	CPreprocessed  map[string]bool
+	EOLClosable    map[string]bool
+	NoCharLiterals map[string]bool
+	SQLQuoting     map[string]bool
+	SingleQuoted   map[string]bool
+	RegionMarked   map[string]bool
+	// Languages that C headers are folded into, by preference
+	CHeaderPriority []string
+}
+
+// DefaultTables - the built-in rule sets.  The slices and maps are
+// shared with the package, so copy one before modifying its entries.
+func DefaultTables() LanguageTables {
+	return LanguageTables{
+		Generic:         genericLanguages,
+		Scripting:       scriptingLanguages,
+		PascalLikes:     pascalLikes,
+		FortranLikes:    fortranLikes,
+		CPreprocessed:   cPreprocessed,
+		EOLClosable:     eolClosable,
+		NoCharLiterals:  noCharLiterals,
+		SQLQuoting:      sqlQuoting,
+		SingleQuoted:    singleQuoted,
+		RegionMarked:    regionMarked,
+		CHeaderPriority: cHeaderPriority,
+	}
+}
+
 var neverInterestingByPrefix []string
 var neverInterestingByInfix []string
 var neverInterestingBySuffix map[string]bool
+var neverInterestingByCompoundSuffix []string // like .min.js
 var neverInterestingByBasename map[string]bool
 
+// Shell startup files, which are counted despite their leading dots
+var shellDotfiles map[string]string
+
 var cHeaderPriority []string
+var cPreprocessed map[string]bool
+var eolClosable map[string]bool
+var noCharLiterals map[string]bool
+var sqlQuoting map[string]bool
+var singleQuoted map[string]bool
+var regionMarked map[string]bool
 var generated string
 
 func init() {
@@ -312,90 +556,125 @@ func init() {
 	// All entries for a given language should be in a contiguous span,
 	// otherwise the primitive duplicate director in listLanguages will
 	// be foiled.
+	//
+	// Comment leaders and trailers may be of any nonzero length.
 	genericLanguages = []genericLanguage{
 		/* C family */
-		{"c", ".c", "/*", "*/", "//", "", true, nil},
-		{"c-header", ".h", "/*", "*/", "//", "", true, nil},
-		{"c-header", ".hpp", "/*", "*/", "//", "", true, nil},
-		{"c-header", ".hxx", "/*", "*/", "//", "", true, nil},
-		{"yacc", ".y", "/*", "*/", "//", "", true, nil},
-		{"lex", ".l", "/*", "*/", "//", "", true, reallyLex},
-		{"c++", ".cpp", "/*", "*/", "//", "", true, nil},
-		{"c++", ".cxx", "/*", "*/", "//", "", true, nil},
-		{"c++", ".cc", "/*", "*/", "//", "", true, nil},
-		{"java", ".java", "/*", "*/", "//", "", true, nil},
-		{"javascript", ".js", "/*", "*/", "//", "", true, nil},
-		{"obj-c", ".m", "/*", "*/", "//", "", true, reallyObjectiveC},
-		{"c#", ".cs", "/*", "*/", "//", "", true, nil},
-		{"php", ".php", "/*", "*/", "//", "", true, nil},
-		{"php3", ".php", "/*", "*/", "//", "", true, nil},
-		{"php4", ".php", "/*", "*/", "//", "", true, nil},
-		{"php5", ".php", "/*", "*/", "//", "", true, nil},
-		{"php6", ".php", "/*", "*/", "//", "", true, nil},
-		{"php7", ".php", "/*", "*/", "//", "", true, nil},
-		{"go", ".go", "/*", "*/", "//", "`", true, nil},
-		{"swift", ".swift", "/*", "*/", "//", "", true, nil},
-		{"sql", ".sql", "/*", "*/", "--", "", false, nil},
-		{"haskell", ".hs", "{-", "-}", "--", "", true, nil},
-		{"pl/1", ".pl1", "/*", "*/", "", "", true, nil},
+		{"c", ".c", "/*", "*/", "//", "", true, false, nil},
+		{"c-header", ".h", "/*", "*/", "//", "", true, false, nil},
+		{"c-header", ".hpp", "/*", "*/", "//", "", true, false, nil},
+		{"c-header", ".hxx", "/*", "*/", "//", "", true, false, nil},
+		{"yacc", ".y", "/*", "*/", "//", "", true, false, nil},
+		{"lex", ".l", "/*", "*/", "//", "", true, false, reallyLex},
+		{"c++", ".cpp", "/*", "*/", "//", "", true, false, nil},
+		{"c++", ".cxx", "/*", "*/", "//", "", true, false, nil},
+		{"c++", ".cc", "/*", "*/", "//", "", true, false, nil},
+		{"java", ".java", "/*", "*/", "//", "", true, false, nil},
+		{"javascript", ".js", "/*", "*/", "//", "", true, false, nil},
+		{"typescript", ".ts", "/*", "*/", "//", "`", true, false, reallyTypeScript},
+		{"typescript", ".mts", "/*", "*/", "//", "`", true, false, nil},
+		{"typescript", ".cts", "/*", "*/", "//", "`", true, false, nil},
+		{"obj-c", ".m", "/*", "*/", "//", "", true, false, reallyObjectiveC},
+		{"c#", ".cs", "/*", "*/", "//", "", true, false, nil},
+		{"php", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"php", ".inc", "/*", "*/", "//", "", true, false, reallyPHP},
+		{"php3", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"php4", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"php5", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"php6", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"php7", ".php", "/*", "*/", "//", "", true, false, nil},
+		{"go", ".go", "/*", "*/", "//", "`", true, false, nil},
+		{"swift", ".swift", "/*", "*/", "//", "", true, false, nil},
+		{"openscad", ".scad", "/*", "*/", "//", "", true, false, nil},
+		{"pov-ray", ".pov", "/*", "*/", "//", "", true, true, nil},
+		{"pov-ray", ".inc", "/*", "*/", "//", "", true, true, reallyPOVRay},
+		{"scala", ".scala", "/*", "*/", "//", dt, true, true, nil},
+		{"scala", ".sbt", "/*", "*/", "//", dt, true, true, nil},
+		{"vala", ".vala", "/*", "*/", "//", dt, true, false, nil},
+		{"vala", ".vapi", "/*", "*/", "//", dt, true, false, nil},
+		{"genie", ".gs", "/*", "*/", "//", dt, true, false, reallyGenie},
+		{"t-sql", ".sql", "/*", "*/", "--", "", false, false, reallyTSQL},
+		{"sql", ".sql", "/*", "*/", "--", "", false, false, nil},
+		{"pl/sql", ".pks", "/*", "*/", "--", "", false, false, nil},
+		{"pl/sql", ".pkb", "/*", "*/", "--", "", false, false, nil},
+		{"pl/sql", ".pls", "/*", "*/", "--", "", false, false, nil},
+		{"apex", ".cls", "/*", "*/", "//", "", true, false, reallyApex},
+		{"apex", ".trigger", "/*", "*/", "//", "", true, false, nil},
+		{"solidity", ".sol", "/*", "*/", "//", "", true, false, nil},
+		{"protobuf", ".proto", "/*", "*/", "//", "", true, false, nil},
+		{"haskell", ".hs", "{-", "-}", "--", "", true, false, nil},
+		{"lean", ".lean", "/-", "-/", "--", "", true, true, nil},
+		{"agda", ".agda", "{-", "-}", "--", "", true, true, nil},
+		{"pl/1", ".pl1", "/*", "*/", "", "", true, false, nil},
+		{"webassembly", ".wat", "(;", ";)", ";;", "", true, true, nil},
+		{"glsl", ".glsl", "/*", "*/", "//", "", true, false, nil},
+		{"glsl", ".vert", "/*", "*/", "//", "", true, false, nil},
+		{"glsl", ".frag", "/*", "*/", "//", "", true, false, nil},
+		{"hlsl", ".hlsl", "/*", "*/", "//", "", true, false, nil},
+		{"idl", ".idl", "/*", "*/", "//", "", true, false, nil}, // CORBA IDL
+		{"asn.1", ".asn1", "/*", "*/", "--", "", true, false, nil},
+		{"asn.1", ".asn", "/*", "*/", "--", "", true, false, nil},
+		{"coq", ".v", "(*", "*)", "", "", true, true, reallyCoq},
+		// Backticks lead compiler directives here, not raw strings
+		{"verilog", ".v", "/*", "*/", "//", "", true, false, nil},
+		{"verilog", ".vh", "/*", "*/", "//", "", true, false, nil},
+		{"systemverilog", ".sv", "/*", "*/", "//", "", true, false, nil},
+		{"systemverilog", ".svh", "/*", "*/", "//", "", true, false, nil},
 		/* everything else */
-		{"asm", ".asm", "", "", ";", "", true, nil},
-		{"asm", ".s", "", "", ";", "", true, nil},
-		{"asm", ".S", "", "", ";", "", true, nil},
-		{"ada", ".ada", "", "", "--", "", true, nil},
-		{"ada", ".adb", "", "", "--", "", true, nil},
-		{"ada", ".ads", "", "", "--", "", true, nil},
-		{"ada", ".pad", "", "", "--", "", true, nil}, // Oracle Ada preprocessoer.
-		{"css", ".css", "/*", "*/", "", "", true, nil},
-		{"makefile", ".mk", "", "", "#", "", true, nil},
-		{"makefile", "Makefile", "", "", "#", "", true, nil},
-		{"makefile", "makefile", "", "", "#", "", true, nil},
-		{"makefile", "Imakefile", "", "", "#", "", true, nil},
-		{"m4", ".m4", "", "", "#", "", true, nil},
-		{"lisp", ".lisp", "", "", ";", "", true, nil},
-		{"lisp", ".lsp", "", "", ";", "", true, nil}, // XLISP
-		{"lisp", ".cl", "", "", ";", "", true, nil},  // Common Lisp
-		{"lisp", ".l", "", "", ";", "", true, nil},
-		{"scheme", ".scm", "", "", ";", "", true, nil},
-		{"elisp", ".el", "", "", ";", "", true, nil},    // Emacs Lisp
-		{"clojure", ".clj", "", "", ";", "", true, nil}, // Clojure
-		{"clojure", ".cljc", "", "", ";", "", true, nil},
-		{"clojurescript", ".cljs", "", "", ";", "", true, nil},
-		{"cobol", ".CBL", "", "", "*", "", true, nil},
-		{"cobol", ".cbl", "", "", "*", "", true, nil},
-		{"cobol", ".COB", "", "", "*", "", true, nil},
-		{"cobol", ".cob", "", "", "*", "", true, nil},
-		{"eiffel", ".e", "", "", "--", "", true, nil},
-		{"sather", ".sa", "", "", "--", "", true, reallySather},
-		{"lua", ".lua", "", "", "--", "", true, nil},
-		{"clu", ".clu", "", "", "%", "", true, nil},
-		{"rust", ".rs", "", "", "//", "", true, nil},
-		{"rust", ".rlib", "", "", "//", "", true, nil},
-		{"erlang", ".erl", "", "", "%", "", true, nil},
-		//{"turing", ".t", "", "", "%", "", true, nil},
-		{"d", ".d", "", "", "//", "", true, nil},
-		{"occam", ".f", "", "", "//", "", true, realllyOccam},
-		{"prolog", ".pl", "", "", "%", "", true, reallyProlog},
-		{"mumps", ".m", "", "", ";", "", true, nil},
-		{"pop11", ".p", "", "", ";", "", true, reallyPOP11},
+		{"asm", ".asm", "", "", ";", "", true, false, nil},
+		{"asm", ".s", "", "", ";", "", true, false, nil},
+		{"asm", ".S", "", "", ";", "", true, false, nil},
+		{"asm", ".inc", "", "", ";", "", true, false, reallyAsmInclude},
+		{"llvm-ir", ".ll", "", "", ";", "", true, false, nil},
+		{"css", ".css", "/*", "*/", "", "", true, false, nil},
+		{"makefile", ".mk", "", "", "#", "", true, false, nil},
+		{"makefile", "Makefile", "", "", "#", "", true, false, nil},
+		{"makefile", "makefile", "", "", "#", "", true, false, nil},
+		{"makefile", "Imakefile", "", "", "#", "", true, false, nil},
+		{"m4", ".m4", "", "", "#", "", true, false, nil},
+		{"lisp", ".lisp", "", "", ";", "", true, false, nil},
+		{"lisp", ".lsp", "", "", ";", "", true, false, nil}, // XLISP
+		{"lisp", ".cl", "", "", ";", "", true, false, nil},  // Common Lisp
+		{"lisp", ".l", "", "", ";", "", true, false, nil},
+		{"scheme", ".scm", "", "", ";", "", true, false, nil},
+		{"elisp", ".el", "", "", ";", "", true, false, nil},    // Emacs Lisp
+		{"clojure", ".clj", "", "", ";", "", true, false, nil}, // Clojure
+		{"clojure", ".cljc", "", "", ";", "", true, false, nil},
+		{"clojurescript", ".cljs", "", "", ";", "", true, false, nil},
+		{"cobol", ".CBL", "", "", "*", "", true, false, nil},
+		{"cobol", ".cbl", "", "", "*", "", true, false, nil},
+		{"cobol", ".COB", "", "", "*", "", true, false, nil},
+		{"cobol", ".cob", "", "", "*", "", true, false, nil},
+		{"eiffel", ".e", "", "", "--", "", true, false, nil},
+		{"sather", ".sa", "", "", "--", "", true, false, reallySather},
+		{"lua", ".lua", "", "", "--", "", true, false, nil},
+		{"clu", ".clu", "", "", "%", "", true, false, nil},
+		{"rust", ".rs", "", "", "//", "", true, false, nil},
+		{"rust", ".rlib", "", "", "//", "", true, false, nil},
+		{"erlang", ".erl", "", "", "%", "", true, false, nil},
+		//{"turing", ".t", "", "", "%", "", true, false, nil},
+		{"occam", ".f", "", "", "//", "", true, false, realllyOccam},
+		{"prolog", ".pl", "", "", "%", "", true, false, reallyProlog},
+		{"mumps", ".m", "", "", ";", "", true, false, nil},
+		{"pop11", ".p", "", "", ";", "", true, false, reallyPOP11},
 		// autoconf cruft
-		{"autotools", "config.h.in", "/*", "*/", "//", "", true, nil},
-		{"autotools", "autogen.sh", "", "", "#", "", true, nil},
-		{"autotools", "configure.in", "", "", "#", "", true, nil},
-		{"autotools", "Makefile.in", "", "", "#", "", true, nil},
-		{"autotools", ".am", "", "", "#", "", true, nil},
-		{"autotools", ".ac", "", "", "#", "", true, nil},
-		{"autotools", ".mf", "", "", "#", "", true, nil},
+		{"autotools", "config.h.in", "/*", "*/", "//", "", true, false, nil},
+		{"autotools", "autogen.sh", "", "", "#", "", true, false, nil},
+		{"autotools", "configure.in", "", "", "#", "", true, false, nil},
+		{"autotools", "Makefile.in", "", "", "#", "", true, false, nil},
+		{"autotools", ".am", "", "", "#", "", true, false, nil},
+		{"autotools", ".ac", "", "", "#", "", true, false, nil},
+		{"autotools", ".mf", "", "", "#", "", true, false, nil},
 		// Scons
-		{"scons", "SConstruct", "", "", "#", "", true, nil},
+		{"scons", "SConstruct", "", "", "#", "", true, false, nil},
 	}
 
 	var err error
-	dtriple, err = regexp.Compile(dt + "." + dt)
+	dtriple, err = regexp.Compile(dt + ".*?" + dt)
 	if err != nil {
 		panic(err)
 	}
-	striple, err = regexp.Compile(st + "." + st)
+	striple, err = regexp.Compile(st + ".*?" + st)
 	if err != nil {
 		panic(err)
 	}
@@ -420,25 +699,29 @@ func init() {
 		{"tcl", ".tcl", "tcl", nil}, /* before sh, because tclsh */
 		{"tcl", ".tcl", "wish", nil},
 		{"csh", ".csh", "csh", nil},
+		/* Dialects go before sh, which their names contain */
+		{"bash", ".bash", "bash", nil},
+		{"zsh", ".zsh", "zsh", nil},
+		{"ksh", ".ksh", "ksh", nil},
+		{"fish", ".fish", "fish", nil},
 		{"shell", ".sh", "sh", nil},
-		{"ruby", ".rb", "ruby", nil},
 		{"awk", ".awk", "awk", nil},
 		{"sed", ".sed", "sed", nil},
 		{"expect", ".exp", "expect", reallyExpect},
 	}
 	pascalLikes = []pascalLike{
-		{"pascal", ".pas", true, nil},
-		{"pascal", ".p", true, reallyPascal},
-		{"pascal", ".inc", true, reallyPascal},
-		{"modula3", ".i3", false, nil},
-		{"modula3", ".m3", false, nil},
-		{"modula3", ".ig", false, nil},
-		{"modula3", ".mg", false, nil},
-		{"ml", ".ml", false, nil},
-		{"mli", ".ml", false, nil},
-		{"mll", ".ml", false, nil},
-		{"mly", ".ml", false, nil},
-		{"oberon", ".mod", false, nil},
+		{"pascal", ".pas", true, false, nil},
+		{"pascal", ".p", true, false, reallyPascal},
+		{"pascal", ".inc", true, false, reallyPascal},
+		{"modula3", ".i3", false, false, nil},
+		{"modula3", ".m3", false, false, nil},
+		{"modula3", ".ig", false, false, nil},
+		{"modula3", ".mg", false, false, nil},
+		{"ml", ".ml", false, true, nil},
+		{"mli", ".mli", false, true, nil},
+		{"mll", ".mll", false, true, nil}, // ocamllex
+		{"mly", ".mly", false, true, nil}, // ocamlyacc
+		{"oberon", ".mod", false, false, nil},
 	}
 
 	var ferr error
@@ -459,9 +742,9 @@ func init() {
 		panic("unexpected failure while building f77 nocomment analyzer")
 	}
 	fortranLikes = []fortranLike{
-		{"fortran90", ".f90", f90comment, f90nocomment},
-		{"fortran", ".f77", f77comment, f77nocomment},
-		{"fortran", ".f", f77comment, f77nocomment},
+		{"fortran90", ".f90", f90comment, f90nocomment, false},
+		{"fortran", ".f77", f77comment, f77nocomment, true},
+		{"fortran", ".f", f77comment, f77nocomment, true},
 	}
 
 	var perr error
@@ -469,6 +752,63 @@ func init() {
 	if perr != nil {
 		panic(perr)
 	}
+	heredocStart, perr = regexp.Compile("^<<[~-]?([\"'`]?)([A-Za-z_][A-Za-z0-9_]*)")
+	if perr != nil {
+		panic(perr)
+	}
+	regionMarker, perr = regexp.Compile(`^[ \t]*(pragma[ \t]+)?(end)?region\b`)
+	if perr != nil {
+		panic(perr)
+	}
+	apiTypeLine, perr = regexp.Compile(`\b(class|struct|union|enum|interface|trait|protocol|typedef|type|namespace|record|object|impl|extension)\b`)
+	if perr != nil {
+		panic(perr)
+	}
+	apiScopeOpener, perr = regexp.Compile(`\b(class|struct|union|enum|interface|trait|protocol|namespace|record|object|impl|extension|extern)\b`)
+	if perr != nil {
+		panic(perr)
+	}
+	apiCallLike, perr = regexp.Compile(`\w\s*\(`)
+	if perr != nil {
+		panic(perr)
+	}
+	apiControl, perr = regexp.Compile(`^\s*(if|else|for|while|do|switch|case|return|sizeof|catch|import|package|using|new|throw|defer|go|await)\b`)
+	if perr != nil {
+		panic(perr)
+	}
+	pythonDecl, perr = regexp.Compile(`^\s*(async\s+)?(def|class|func)\s`)
+	if perr != nil {
+		panic(perr)
+	}
+	goModuleDecl, perr = regexp.Compile(`(?m)^module\s+"?([^\s"]+)`)
+	if perr != nil {
+		panic(perr)
+	}
+	javaPackageDecl, perr = regexp.Compile(`^\s*package\s+([\w.]+)\s*;`)
+	if perr != nil {
+		panic(perr)
+	}
+	sqlClientCommand, perr = regexp.Compile(`(?i)^(GO([ \t]+[0-9]+)?|DELIMITER[ \t]+(\S+))[ \t]*(\r?\n|$)`)
+	if perr != nil {
+		panic(perr)
+	}
+	texVerbatim, perr = regexp.Compile("\\\\begin\\{(verbatim\\*?|Verbatim|lstlisting|minted)\\}")
+	if perr != nil {
+		panic(perr)
+	}
+	scriptTag, perr = regexp.Compile(`(?i)<script\b([^>]*)>`)
+	if perr != nil {
+		panic(perr)
+	}
+	scriptTS, perr = regexp.Compile(`(?i)\blang\s*=\s*["']?(ts|typescript)\b`)
+	if perr != nil {
+		panic(perr)
+	}
+	sqlKeywords := "(SELECT|INSERT|UPDATE|DELETE|WITH|CREATE|ALTER|DROP|MERGE)\\b"
+	sqlStart, perr = regexp.Compile("(?i)^\\s*" + sqlKeywords)
+	if perr != nil {
+		panic(perr)
+	}
 
 	neverInterestingByPrefix = []string{"."}
 	neverInterestingByInfix = []string{".so.", "/."}
@@ -499,8 +839,43 @@ func init() {
 		"config.status": true,
 		"lex.yy.c":      true, "lex.yy.cc": true,
 		"y.code.c": true, "y.tab.c": true, "y.tab.h": true,
+		"go.mod": true, // a Go module manifest, not Oberon
+	}
+	shellDotfiles = map[string]string{
+		".bashrc": "bash", ".bash_profile": "bash", ".bash_login": "bash",
+		".bash_logout": "bash", ".bash_aliases": "bash",
+		".zshrc": "zsh", ".zshenv": "zsh", ".zprofile": "zsh",
+		".zlogin": "zsh", ".zlogout": "zsh",
+		".kshrc": "ksh", ".mkshrc": "ksh",
+		".cshrc": "csh", ".tcshrc": "csh", ".login": "csh", ".logout": "csh",
+		".profile": "shell",
 	}
 	cHeaderPriority = []string{"c", "c++", "obj-c"}
+	// Languages that go through the C preprocessor, which splices
+	// backslash-continued lines before comments are stripped.
+	cPreprocessed = map[string]bool{
+		"c": true, "c-header": true, "c++": true, "obj-c": true,
+		"yacc": true, "lex": true, "glsl": true, "hlsl": true,
+	}
+	// Languages in which a second winged-comment leader on the same
+	// line ends the comment, as -- does in ASN.1.
+	eolClosable = map[string]bool{"asn.1": true}
+	// Languages in which ' marks a sized literal such as 4'b1010
+	// rather than opening a character literal.
+	noCharLiterals = map[string]bool{"verilog": true, "systemverilog": true,
+		// A ' may end a name, as in x', in the proof assistants.
+		"coq": true, "lean": true, "agda": true}
+	// Languages whose '...' strings may span lines and escape a
+	// quote by doubling it rather than with a backslash.
+	sqlQuoting = map[string]bool{"sql": true, "pl/sql": true, "t-sql": true}
+	// Languages whose '...' literals are strings with backslash
+	// escapes, as in Apex, Solidity, and Protocol Buffers.
+	singleQuoted = map[string]bool{"apex": true, "solidity": true,
+		"protobuf": true}
+	// Languages with #region/#endregion or #pragma region folding
+	// markers, which -no-count-regions leaves out.
+	regionMarked = map[string]bool{"c#": true, "c": true, "c++": true,
+		"c-header": true}
 
 	generated = "automatically generated|generated automatically|generated by|a lexical scanner generated by flex|this is a generated file|generated with the.*utility|do not edit|do not hand-hack"
 
@@ -516,27 +891,325 @@ const INCOMMENT = 3     // in comment
 type countContext struct {
 	line             []byte
 	lineNumber       uint
-	nonblank         bool // Is current line nonblank?
-	lexfile          bool // Do we see lex directives?
-	wasNewline       bool // Was the last character seen a newline?
+	nonblank         bool            // Is current line nonblank?
+	lexfile          bool            // Do we see lex directives?
+	wasNewline       bool            // Was the last character seen a newline?
+	preproc          uint64          // Preprocessor directive lines seen
+	tables           *LanguageTables // nil means the defaults
+	codeLines        map[uint]bool   // lines counted as code, for -blame
+	effective        uint64          // Lines ending a statement, for -effective
+	logical          bool            // Did the counter tally effective lines?
+	api              uint64          // Declaration lines, for -api
+	gzipped          bool            // Read path.gz through gunzip, for -gzip
+	gzipWarned       bool            // Has corruption in path.gz been reported?
+	sqlLines         map[uint]bool   // SQL lines in strings, for -embedded-sql
+	leading          map[uint]string // indentation of lines that have any, for -indent
+	commentLines     map[uint]bool   // lines holding a comment, for -comment-density
+	problems         []string        // unterminated comments and strings, for -strict
+	indentDone       bool            // Is the current line's indentation recorded?
+	indentation      []byte          // current line's indentation so far
+	confidence       float64         // score of the last verifier run
+	extracted        []byte          // text counted in place of the file, for components
 	underlyingStream *os.File
 	rc               *bufio.Reader
 }
 
 func (ctx *countContext) setup(path string) bool {
 	var err error
+	if ctx.extracted != nil {
+		ctx.underlyingStream = nil
+		ctx.rc = bufio.NewReader(bytes.NewReader(ctx.extracted))
+		ctx.lineNumber = 1
+		return true
+	}
+	if ctx.gzipped {
+		path += ".gz"
+	}
 	ctx.underlyingStream, err = os.Open(path)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	ctx.rc = bufio.NewReader(ctx.underlyingStream)
+	var r io.Reader = ctx.underlyingStream
+	if ctx.gzipped {
+		r = &gunzipReader{ctx: ctx, path: path}
+	}
+	ctx.rc = bufio.NewReader(r)
 	ctx.lineNumber = 1
 	return true
 }
 
+// gunzipReader - decompress a file for counting.  Corruption is reported
+// once per file and then read as end of file, so the counters see a
+// short file rather than a read error.
+type gunzipReader struct {
+	ctx  *countContext
+	path string
+	gz   *gzip.Reader
+}
+
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if g.gz == nil {
+		g.gz, err = gzip.NewReader(g.ctx.underlyingStream)
+	}
+	if err == nil {
+		n, err = g.gz.Read(p)
+	}
+	if err != nil && err != io.EOF {
+		if !g.ctx.gzipWarned {
+			log.Printf("%s: %v\n", g.path, err)
+			g.ctx.gzipWarned = true
+		}
+		err = io.EOF
+	}
+	return n, err
+}
+
+// countLine - note that a line was counted as code, for -blame
+func (ctx *countContext) countLine(line uint) {
+	if ctx.codeLines != nil {
+		ctx.codeLines[line] = true
+	}
+}
+
+// commented - note that a comment starts on a line
+func (ctx *countContext) commented(line uint) {
+	if ctx.commentLines != nil {
+		ctx.commentLines[line] = true
+	}
+}
+
+// apiScanner - picks out the declaration lines of a C-family file,
+// for -api.  A line outside function bodies counts if it declares a
+// type or names a function, as does any line the parameter list of
+// such a declaration runs onto.  The bodies of classes, structs,
+// interfaces, and namespaces are not function bodies.
+type apiScanner struct {
+	code      []byte // code on this line, without strings or comments
+	header    []byte // code since the last statement or brace
+	scopes    []bool // for each enclosing brace, is it a body?
+	bodies    int    // enclosing braces that are bodies
+	outside   bool   // did this line start outside any body?
+	parens    int    // parentheses open
+	signature bool   // is a declaration's parameter list open?
+}
+
+// Declarations whose headers may hold parentheses, as a Kotlin class
+// or a Java record's do.  A header like struct's with them belongs to
+// a function returning one.
+var classLike = map[string]bool{"class": true, "object": true, "record": true}
+
+// see - take in a character of code
+func (a *apiScanner) see(c byte) {
+	a.code = append(a.code, c)
+	a.header = append(a.header, c)
+	switch c {
+	case '(':
+		a.parens++
+	case ')':
+		if a.parens > 0 {
+			a.parens--
+		}
+	case '{':
+		m := apiScopeOpener.FindSubmatch(a.header)
+		body := m == nil || (bytes.IndexByte(a.header, '(') > -1 && !classLike[string(m[1])])
+		a.scopes = append(a.scopes, body)
+		if body {
+			a.bodies++
+		}
+		a.header = a.header[:0]
+	case '}':
+		if n := len(a.scopes); n > 0 {
+			if a.scopes[n-1] {
+				a.bodies--
+			}
+			a.scopes = a.scopes[:n-1]
+		}
+		a.header = a.header[:0]
+	case ';':
+		a.header = a.header[:0]
+	}
+}
+
+// endLine - finish a line, which was counted as code or not, and
+// say whether it was a declaration
+func (a *apiScanner) endLine(counted bool, directive bool) bool {
+	decl := counted && !directive && a.outside && (a.signature ||
+		apiTypeLine.Match(a.code) ||
+		(apiCallLike.Match(a.code) && !apiControl.Match(a.code)))
+	if decl {
+		a.signature = a.parens > 0
+	}
+	a.code = a.code[:0]
+	a.outside = a.bodies == 0
+	return decl
+}
+
+// pythonScope - a def or class that encloses the lines indented
+// deeper than it, for -api
+type pythonScope struct {
+	indent uint64
+	class  bool
+}
+
+// pythonAPI - say whether a Python line, at the given indentation,
+// is a def or class outside any function, keeping track of the
+// enclosing definitions
+func pythonAPI(scopes []pythonScope, indent uint64, code []byte) ([]pythonScope, bool) {
+	for len(scopes) > 0 && scopes[len(scopes)-1].indent >= indent {
+		scopes = scopes[:len(scopes)-1]
+	}
+	m := pythonDecl.FindSubmatch(code)
+	if m == nil {
+		return scopes, false
+	}
+	outside := true
+	for _, scope := range scopes {
+		outside = outside && scope.class
+	}
+	return append(scopes, pythonScope{indent, string(m[2]) == "class"}), outside
+}
+
+// pythonStrings - the string literals of Python source, scanned a
+// line at a time for -embedded-sql.  Each body is handed to
+// embeddedSQL as its string closes.
+type pythonStrings struct {
+	quote []byte // delimiter of a triple-quoted string left open
+	body  []byte // its text so far
+	first uint   // the line it opened on
+}
+
+func (p *pythonStrings) scan(ctx *countContext, line []byte) {
+	for i := 0; i < len(line); i++ {
+		if p.quote != nil {
+			if bytes.HasPrefix(line[i:], p.quote) {
+				ctx.embeddedSQL(p.body, p.first, ctx.lineNumber-1)
+				i += len(p.quote) - 1
+				p.quote = nil
+			} else {
+				if line[i] == '\\' && i+1 < len(line) {
+					p.body = append(p.body, line[i])
+					i++
+				}
+				p.body = append(p.body, line[i])
+			}
+			continue
+		}
+		c := line[i]
+		if c == '#' {
+			return
+		} else if c != '"' && c != '\'' {
+			continue
+		}
+		if bytes.HasPrefix(line[i:], []byte{c, c, c}) {
+			p.quote = []byte{c, c, c}
+			p.body = p.body[:0]
+			p.first = ctx.lineNumber - 1
+			i += 2
+			continue
+		}
+		// A single-quoted string ends on its line.
+		j := i + 1
+		for j < len(line) && line[j] != c && line[j] != '\n' {
+			if line[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j > len(line) {
+			j = len(line)
+		}
+		ctx.embeddedSQL(line[i+1:j], ctx.lineNumber-1, ctx.lineNumber-1)
+		i = j
+	}
+}
+
+// indentWidth - the columns taken by a line's indentation, with tab
+// stops every tab columns
+func indentWidth(indentation string, tab int) uint64 {
+	var width int
+	for _, c := range indentation {
+		if c == '\t' {
+			width = (width/tab + 1) * tab
+		} else {
+			width++
+		}
+	}
+	return uint64(width)
+}
+
+// tabWidth - the tab width -tab-width sets for a language
+func tabWidth(lang string) int {
+	if n, ok := tabWidths[lang]; ok {
+		return n
+	}
+	return tabWidths[""]
+}
+
+// meanIndent - the mean indentation of some code lines
+func meanIndent(indent uint64, lines uint64) float64 {
+	if lines == 0 {
+		return 0
+	}
+	return float64(indent) / float64(lines)
+}
+
+// commentShare - the fraction of code lines that carry a comment
+func commentShare(commented uint64, lines uint64) float64 {
+	if lines == 0 {
+		return 0
+	}
+	return float64(commented) / float64(lines)
+}
+
+// unterminated - complain of a file that ends inside a comment or
+// string, keeping the complaint for -strict.  A line of 0 means the
+// counter doesn't know where the construct began.
+func (ctx *countContext) unterminated(path string, line uint, what string) {
+	var complaint string
+	if line > 0 {
+		complaint = fmt.Sprintf("%q, line %d: ERROR - terminated in %s beginning here", path, line, what)
+	} else {
+		complaint = fmt.Sprintf("%q: ERROR - terminated in %s", path, what)
+	}
+	log.Println(complaint)
+	ctx.problems = append(ctx.problems, complaint)
+}
+
+// embeddedSQL - note the lines of a string literal whose body looks
+// like an SQL statement
+func (ctx *countContext) embeddedSQL(body []byte, first uint, last uint) {
+	if ctx.sqlLines != nil && sqlStart.Match(body) {
+		for line := first; line <= last; line++ {
+			ctx.sqlLines[line] = true
+		}
+	}
+}
+
+// rules - the language tables in force for this count
+func (ctx *countContext) rules() *LanguageTables {
+	if ctx.tables == nil {
+		defaults := DefaultTables()
+		return &defaults
+	}
+	return ctx.tables
+}
+
 func (ctx *countContext) teardown() {
-	ctx.underlyingStream.Close()
+	if ctx.underlyingStream != nil {
+		ctx.underlyingStream.Close()
+	}
+}
+
+// skipHashbang - consume a #! line at the start of a file.  Such a line
+// is never counted as code, whatever the language's comment syntax.
+func (ctx *countContext) skipHashbang() {
+	if ctx.consume([]byte("#!")) {
+		ctx.rc.ReadBytes('\n')
+		ctx.lineNumber++
+	}
 }
 
 // consume - conditionally consume an expected byte sequence
@@ -549,6 +1222,13 @@ func (ctx *countContext) consume(expect []byte) bool {
 	return false
 }
 
+// opens - does the delimiter begin with c and continue with what's
+// next in the input?  On a match the rest of the delimiter is consumed,
+// so delimiters of any length work.
+func (ctx *countContext) opens(c byte, delim string) bool {
+	return delim != "" && c == delim[0] && ctx.consume([]byte(delim[1:]))
+}
+
 func (ctx *countContext) ispeek(c byte) bool {
 	if s, err := ctx.rc.Peek(1); err == nil && s[0] == c {
 		return true
@@ -564,6 +1244,18 @@ func (ctx *countContext) getachar() (byte, error) {
 	}
 	if ctx.wasNewline {
 		ctx.lineNumber++
+		ctx.indentDone = false
+		ctx.indentation = ctx.indentation[:0]
+	}
+	if ctx.leading != nil && !ctx.indentDone && err == nil {
+		if c == ' ' || c == '\t' {
+			ctx.indentation = append(ctx.indentation, c)
+		} else {
+			if c != '\n' && len(ctx.indentation) > 0 {
+				ctx.leading[ctx.lineNumber] = string(ctx.indentation)
+			}
+			ctx.indentDone = true
+		}
 	}
 	if c == '\n' {
 		ctx.wasNewline = true
@@ -579,6 +1271,11 @@ func (ctx *countContext) munchline() bool {
 	if err == nil {
 		ctx.lineNumber++
 		ctx.line = line
+		if ctx.leading != nil {
+			if n := len(line) - len(bytes.TrimLeft(line, " \t")); n > 0 {
+				ctx.leading[ctx.lineNumber-1] = string(line[:n])
+			}
+		}
 		return true
 	} else if err == io.EOF {
 		return false
@@ -609,16 +1306,61 @@ func isspace(c byte) bool {
 	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f'
 }
 
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
 // Verifier functions for checking that files with disputed extensions
 // are actually of the types we think they are.
+//
+// Each verifier is a wrapper around a scorer, which returns how
+// confident it is in the classification, from 0 to 1.  Scores at or
+// above verifierThreshold claim the file, and more of the signals a
+// scorer looks for means a higher score on either side of the line.
+
+const verifierThreshold = 0.5
+
+// confidence - score a scorer's verdict by the share of the signals it
+// looks for that turned up.  Claimed files score from the threshold up.
+func confidence(claimed bool, seen int, of int) float64 {
+	share := float64(seen) / float64(of)
+	if claimed {
+		return verifierThreshold + (1-verifierThreshold)*share
+	}
+	return verifierThreshold * share * 0.99
+}
+
+// signals - how many of these conditions hold?
+func signals(conditions ...bool) int {
+	n := 0
+	for _, c := range conditions {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+// verify - apply the threshold to a score, remembering the score so
+// that it can be reported with the file's counts
+func (ctx *countContext) verify(score float64) bool {
+	ctx.confidence = score
+	return score >= verifierThreshold
+}
 
 // reallyObjectiveC - returns TRUE if filename contents really are objective-C.
 func reallyObjectiveC(ctx *countContext, path string) bool {
+	return ctx.verify(objectiveCScore(ctx, path))
+}
+
+// objectiveCScore - how much does a file look like Objective-C?
+func objectiveCScore(ctx *countContext, path string) float64 {
 	var isObjC bool = false  // Value to determine.
 	var braceLines int       // Lines that begin/end with curly braces.
 	var plusMinus int        // Lines that begin with + or -.
 	var wordMain int         // Did we find "main("?
 	var special bool = false // Did we find a special Objective-C pattern?
+	var tells bool = false   // Did we find a directive only Objective-C has?
 
 	ctx.setup(path)
 	defer ctx.teardown()
@@ -637,93 +1379,308 @@ func reallyObjectiveC(ctx *countContext, path string) bool {
 		if ctx.matchline("(?i)^\\s*\\[object name\\];\\s*") {
 			special = true
 		}
+		// Modern Objective-C may use properties and dot syntax
+		// throughout, with few braces and no +/- methods.
+		if ctx.matchline("^\\s*(@(interface|implementation|property|end)\\b|#import\\s)") {
+			tells = true
+		}
 
-		if (braceLines > 1) && ((plusMinus > 1) || wordMain > 0 || special) {
+		if tells || (braceLines > 1) && ((plusMinus > 1) || wordMain > 0 || special) {
 			isObjC = true
 		}
 
 	}
 
+	score := confidence(isObjC, signals(braceLines > 1, plusMinus > 1,
+		wordMain > 0, special, tells), 5)
 	if debug > 0 {
-		log.Printf("objc verifier returned %t on %s\n", isObjC, path)
+		log.Printf("objc verifier returned %t (%.2f) on %s\n", isObjC, score, path)
 	}
 
-	return isObjC
+	return score
 }
 
-func hasKeywords(ctx *countContext, path string, lang string, tells []string) bool {
-	var matching bool = false // Value to determine.
+// keywordScore - how many of the tells turn up anywhere in a file?
+// Any one of them is enough to claim it.
+func keywordScore(ctx *countContext, path string, lang string, tells []string) float64 {
+	matched := make([]bool, len(tells))
 
 	ctx.setup(path)
 	defer ctx.teardown()
 
 	for ctx.munchline() {
 		for i := range tells {
-			if ctx.matchline(tells[i]) {
-				matching = true
-				break
+			if !matched[i] && ctx.matchline(tells[i]) {
+				matched[i] = true
 			}
 		}
 	}
 
+	seen := signals(matched...)
+	score := confidence(seen > 0, seen, len(tells))
 	if debug > 0 {
-		log.Printf("%s verifier returned %t on %s\n",
-			lang, matching, path)
+		log.Printf("%s verifier returned %t (%.2f) on %s\n",
+			lang, seen > 0, score, path)
 	}
 
-	return matching
+	return score
 }
 
 // realllyOccam - returns TRUE if filename contents really are occam.
 func realllyOccam(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "occam", []string{"--", "PROC"})
+	return ctx.verify(keywordScore(ctx, path, "occam", []string{"--", "PROC"}))
+}
+
+// reallyNginx - returns TRUE if a .conf file is really Nginx
+// configuration, with its block and simple directives.
+func reallyNginx(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "nginx", []string{
+		"^\\s*(http|events|server|location|upstream)\\b[^;#]*\\{",
+		"^\\s*(listen|server_name|proxy_pass|worker_processes|try_files)\\s[^#]*;",
+	}))
+}
+
+// reallyApache - returns TRUE if a .conf file is really Apache httpd
+// configuration, with its capitalized directives and sections.
+func reallyApache(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "apache", []string{
+		"^\\s*</?(VirtualHost|Directory|Location|IfModule|Files)\\b",
+		"^\\s*(ServerName|ServerRoot|DocumentRoot|LoadModule|RewriteRule)\\s",
+	}))
+}
+
+// reallyPHP - returns TRUE if an .inc file is really PHP, which has
+// to open PHP mode somewhere.
+func reallyPHP(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "php", []string{"<\\?php\\b"}))
+}
+
+// reallyPOVRay - returns TRUE if an .inc file is really a POV-Ray
+// scene include, with its language directives or scene objects.
+// Pascal and PHP have neither.
+func reallyPOVRay(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "pov-ray", []string{
+		"^\\s*#(declare|local|macro|version)\\b",
+		"\\b(camera|light_source|sphere|box|texture|pigment|finish)\\s*\\{",
+	}))
+}
+
+// reallyTSQL - returns TRUE if an .sql file is really Transact-SQL,
+// with GO batch separators, @variables, or the dbo schema.
+func reallyTSQL(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "t-sql", []string{
+		"(?i)^\\s*GO\\s*$",
+		"(?i)\\bSET\\s+NOCOUNT\\s+(ON|OFF)\\b",
+		"(?i)@@(ROWCOUNT|IDENTITY|ERROR|TRANCOUNT)\\b",
+		"(?i)\\bDECLARE\\s+@",
+		"(?i)\\[dbo\\]\\.",
+	}))
+}
+
+// reallyGenie - returns TRUE if a .gs file is really Genie rather
+// than Google Apps Script.  Genie is Vala with indented blocks, so
+// its comments and strings are lexed as Vala's are.
+func reallyGenie(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "genie", []string{
+		"^\\[indent=[0-9]+\\]",
+		"^(init|uses)\\s*$",
+		"^\\s*def\\s+\\w+",
+		"^class\\s+\\w+\\s*:",
+	}))
+}
+
+// reallyAsmInclude - returns TRUE if an .inc file is really an
+// assembler include, with NASM or MASM directives or data
+// definitions.  Pascal, PHP, and POV-Ray have none of these.
+func reallyAsmInclude(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "asm", []string{
+		"(?i)^\\s*%(macro|define|assign|include|ifn?def)\\b",
+		"(?i)^\\s*(section|segment)\\s+\\.?\\w+",
+		"(?i)^\\s*(global|extern)\\s+\\w+\\s*(;|$)",
+		"(?i)^\\s*(\\w+:?\\s+)?(db|dw|dd|dq|resb|resw|resd|resq)\\s+[0-9'\"]",
+		"(?i)^\\s*\\w+\\s+(equ|proc|macro)\\b",
+	}))
 }
 
 // reallyLex - returns TRUE if filename contents really are lex.
 func reallyLex(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "lex", []string{"%{", "%%", "%}"})
+	return ctx.verify(keywordScore(ctx, path, "lex", []string{"%{", "%%", "%}"}))
 }
 
 // reallyPOP11 - returns TRUE if filename contents really are pop11.
 func reallyPOP11(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "pop11", []string{"define", "printf"})
+	return ctx.verify(keywordScore(ctx, path, "pop11", []string{"define", "printf"}))
 }
 
 // reallySather - returns TRUE if filename contents really are sather.
 func reallySather(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "sather", []string{"class"})
+	return ctx.verify(keywordScore(ctx, path, "sather", []string{"class"}))
 }
 
 // reallyProlog - returns TRUE if filename contents really are prolog.
 // Without this check, Perl files will be falsely identified.
 func reallyProlog(ctx *countContext, path string) bool {
+	return ctx.verify(prologScore(ctx, path))
+}
+
+// prologScore - how free is a file of Perlisms?
+func prologScore(ctx *countContext, path string) float64 {
+	var hashLine bool // a line beginning with #
+	var variable bool // something like a Perl scalar
+
 	ctx.setup(path)
 	defer ctx.teardown()
 
 	for ctx.munchline() {
 		if bytes.HasPrefix(ctx.line, []byte("#")) {
-			return false
+			hashLine = true
 		} else if ctx.matchline("\\$[[:alpha]]") {
-			return false
+			variable = true
 		}
 	}
 
-	return true
+	return confidence(!hashLine && !variable, signals(!hashLine, !variable), 2)
 }
 
-// reallyExpect - filename, returns true if its contents really are Expect.
-//
-// dwheeler had this to say:
-//
-// Many "exp" files (such as in Apache and Mesa) are just "export" data,
-// summarizing something else (e.g., its interface).
-// Sometimes (like in RPM) it's just misc. data.
-// Thus, we need to look at the file to determine
-// if it's really an "expect" file.
-// The heuristic is as follows: it's Expect _IF_ it:
-// 1. has "load_lib" command and either "#" comments or {}.
-// 2. {, }, and one of: proc, if, [...], expect
+// reallyCoq - returns TRUE if a .v file's contents really are Coq.
+// Without this check, Verilog modules would be taken for proofs.
+func reallyCoq(ctx *countContext, path string) bool {
+	return ctx.verify(keywordScore(ctx, path, "coq", []string{
+		"^\\s*(Proof|Qed|Defined|Admitted)\\.",
+		"^\\s*(Theorem|Lemma|Definition|Fixpoint|Inductive)\\s",
+		"^\\s*(From\\s+\\S+\\s+)?Require\\s",
+	}))
+}
+
+// reallyCPlusPlus - returns TRUE if a .h file's contents look like C++.
+// This only matters for header-only libraries, whose headers would
+// otherwise be reported under the c-header marker.
+func reallyCPlusPlus(ctx *countContext, path string) bool {
+	return keywordScore(ctx, path, "c++", []string{
+		"^\\s*template\\s*<",
+		"^\\s*namespace\\b",
+		"\\bclass\\s+\\w+\\s*[:{]",
+		"^\\s*(public|protected|private)\\s*:",
+		"\\bstd::",
+	}) >= verifierThreshold
+}
+
+// reallyTypeScript - returns TRUE if filename contents really are
+// TypeScript.  Qt Linguist translation files and MPEG transport
+// streams also end in .ts; the first are XML, the second binary.
+func reallyTypeScript(ctx *countContext, path string) bool {
+	return ctx.verify(typeScriptScore(ctx, path))
+}
+
+// typeScriptScore - does a file open with something other than markup,
+// and hold no NULs?
+func typeScriptScore(ctx *countContext, path string) float64 {
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	if head, _ := ctx.rc.Peek(512); bytes.IndexByte(head, 0) > -1 {
+		if debug > 0 {
+			log.Printf("typescript verifier returned false on binary %s\n", path)
+		}
+		return confidence(false, 0, 1)
+	}
+	for ctx.munchline() {
+		if len(bytes.TrimSpace(ctx.line)) == 0 {
+			continue
+		}
+		isTS := !ctx.matchline("^\\s*<(\\?xml|!DOCTYPE|TS[\\s>])")
+		if debug > 0 {
+			log.Printf("typescript verifier returned %t on %s\n", isTS, path)
+		}
+		return confidence(isTS, signals(isTS), 1)
+	}
+
+	return confidence(true, 1, 1)
+}
+
+// reallyObjCHeader - returns TRUE if a .h file is Objective-C.
+// Headers declaring classes and protocols, or pulling in others with
+// #import, belong with the .m files in an iOS or macOS project rather
+// than with whatever C is about.
+func reallyObjCHeader(ctx *countContext, path string) bool {
+	return keywordScore(ctx, path, "obj-c", []string{
+		"^\\s*@(interface|protocol)\\b",
+		"^\\s*@property\\b",
+		"^\\s*#import\\s",
+	}) >= verifierThreshold
+}
+
+// reallyApex - returns TRUE if a .cls file is Apex rather than a
+// LaTeX document class, which opens with a % comment or a control
+// sequence.
+func reallyApex(ctx *countContext, path string) bool {
+	return ctx.verify(apexScore(ctx, path))
+}
+
+// apexScore - does a file open with something other than TeX?
+func apexScore(ctx *countContext, path string) float64 {
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		if len(bytes.TrimSpace(ctx.line)) == 0 {
+			continue
+		}
+		isApex := !ctx.matchline("^\\s*[\\\\%]")
+		if debug > 0 {
+			log.Printf("apex verifier returned %t on %s\n", isApex, path)
+		}
+		return confidence(isApex, signals(isApex), 1)
+	}
+
+	return confidence(true, 1, 1)
+}
+
+// reallyD - returns TRUE if filename contents really are D.
+// Without this check, the Make dependency files generated by
+// gcc -MD and friends will be falsely identified.  Those begin
+// with a rule line like "foo.o: foo.c foo.h \".
+func reallyD(ctx *countContext, path string) bool {
+	return ctx.verify(dScore(ctx, path))
+}
+
+// dScore - does a file open with something other than a Make rule?
+func dScore(ctx *countContext, path string) float64 {
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		if len(bytes.TrimSpace(ctx.line)) == 0 {
+			continue
+		}
+		isD := !ctx.matchline("^[^\\s:#]+(\\s+[^\\s:]+)*\\s*:(\\s|$)")
+		if debug > 0 {
+			log.Printf("d verifier returned %t on %s\n", isD, path)
+		}
+		return confidence(isD, signals(isD), 1)
+	}
+
+	return confidence(true, 1, 1)
+}
+
+// reallyExpect - filename, returns true if its contents really are Expect.
+//
+// dwheeler had this to say:
+//
+// Many "exp" files (such as in Apache and Mesa) are just "export" data,
+// summarizing something else (e.g., its interface).
+// Sometimes (like in RPM) it's just misc. data.
+// Thus, we need to look at the file to determine
+// if it's really an "expect" file.
+// The heuristic is as follows: it's Expect _IF_ it:
+// 1. has "load_lib" command and either "#" comments or {}.
+// 2. {, }, and one of: proc, if, [...], expect
 func reallyExpect(ctx *countContext, path string) bool {
+	return ctx.verify(expectScore(ctx, path))
+}
+
+// expectScore - how much does a file look like Expect?
+func expectScore(ctx *countContext, path string) float64 {
 	var isExpect = false // Value to determine.
 
 	var beginBrace bool // Lines that begin with curly braces.
@@ -785,15 +1742,22 @@ func reallyExpect(ctx *countContext, path string) bool {
 		isExpect = true
 	}
 
+	score := confidence(isExpect, signals(beginBrace, endBrace, loadLib,
+		foundProc, foundIf, foundBrackets, foundExpect, foundPound), 8)
 	if debug > 0 {
-		log.Printf("expect verifier returned %t on %s\n", isExpect, path)
+		log.Printf("expect verifier returned %t (%.2f) on %s\n", isExpect, score, path)
 	}
 
-	return isExpect
+	return score
 }
 
 // reallyPascal - returns  true if filename contents really are Pascal.
 func reallyPascal(ctx *countContext, path string) bool {
+	return ctx.verify(pascalScore(ctx, path))
+}
+
+// pascalScore - how much does a file look like Pascal?
+func pascalScore(ctx *countContext, path string) float64 {
 	//
 	// dwheeler had this to say:
 	//
@@ -904,11 +1868,13 @@ func reallyPascal(ctx *countContext, path string) bool {
 		(hasModule && foundTerminatingEnd) ||
 		(hasProgram && hasBegin && foundTerminatingEnd))
 
+	score := confidence(isPascal, signals(hasProgram || hasUnit || hasModule,
+		hasProcedureOrFunction, hasBegin, foundTerminatingEnd), 4)
 	if debug > 0 {
-		log.Printf("pascal verifier returned %t on %s\n", isPascal, path)
+		log.Printf("pascal verifier returned %t (%.2f) on %s\n", isPascal, score, path)
 	}
 
-	return isPascal
+	return score
 }
 
 func wasGeneratedAutomatically(ctx *countContext, path string, eolcomment string) bool {
@@ -949,6 +1915,10 @@ func wasGeneratedAutomatically(ctx *countContext, path string, eolcomment string
 
 // hashbang - hunt for a specified string in the first line of an executable
 func hashbang(ctx *countContext, path string, langname string) bool {
+	// With -ext-only, classification goes by extension alone.
+	if extOnly != nil {
+		return false
+	}
 	fi, err := os.Stat(path)
 	// If it's not executable by somebody, don't read for hashbang
 	if err != nil || (fi.Mode()&01111) == 0 {
@@ -960,6 +1930,82 @@ func hashbang(ctx *countContext, path string, langname string) bool {
 	return err == nil && strings.HasPrefix(s, "#!") && strings.Contains(s, langname)
 }
 
+// extractComponent - pull the scripts out of a Svelte or Astro component
+//
+// What gets counted in a component is its script: the <script>
+// elements, and in Astro the frontmatter fenced off by --- lines at
+// the top of the file.  The script is TypeScript if a <script> tag
+// says lang="ts", and Astro frontmatter always is; otherwise it is
+// JavaScript.  Everything else, markup and <style> alike, is blanked
+// out of the returned text, line for line, so that line numbers
+// still match the file.
+func extractComponent(ctx *countContext, path string) (string, []byte) {
+	var text bytes.Buffer
+	var inscript bool // Inside a <script> element?
+	var infront bool  // Inside Astro frontmatter?
+	lang := "javascript"
+	first := true
+
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		line := bytes.TrimRight(ctx.line, "\r\n")
+		if first && strings.HasSuffix(path, ".astro") && string(bytes.TrimSpace(line)) == "---" {
+			infront = true
+			lang = "typescript"
+			line = nil
+		} else if infront {
+			if string(bytes.TrimSpace(line)) == "---" {
+				infront = false
+			} else {
+				text.Write(line)
+			}
+			line = nil
+		}
+		first = false
+		for len(line) > 0 {
+			if inscript {
+				i := bytes.Index(line, []byte("</script"))
+				if i == -1 {
+					text.Write(line)
+					break
+				}
+				text.Write(line[:i])
+				inscript = false
+				line = line[i+len("</script"):]
+			} else if m := scriptTag.FindSubmatchIndex(line); m != nil {
+				if scriptTS.Match(line[m[2]:m[3]]) {
+					lang = "typescript"
+				}
+				inscript = true
+				line = line[m[1]:]
+			} else {
+				break
+			}
+		}
+		text.WriteByte('\n')
+	}
+
+	return lang, text.Bytes()
+}
+
+// componentCounter - count SLOC in the scripts of a Svelte or Astro
+// component, by the rules of the language they're written in
+func componentCounter(ctx *countContext, path string) (string, uint64) {
+	lang, text := extractComponent(ctx, path)
+	for _, syntax := range ctx.rules().Generic {
+		if syntax.name == lang {
+			// The text has been vetted already.
+			syntax.verifier = nil
+			ctx.extracted = text
+			defer func() { ctx.extracted = nil }()
+			return lang, cFamilyCounter(ctx, path, syntax)
+		}
+	}
+	return lang, 0
+}
+
 // cFamilyCounter - Count the SLOC in a C-family source file
 //
 // C++ headers get counted as C. This can only be fixed in postprocessing
@@ -968,14 +2014,25 @@ func hashbang(ctx *countContext, path string, langname string) bool {
 // Another minor issue is that it's possible for the antecedents in Lex rules
 // to look like C comment starts. In theory we could fix this by requiring Lex
 // files to contain %%.
-func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint {
+func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint64 {
 	/* Types of comments: */
 	const BLOCK_COMMENT = 0
 	const TRAILING_COMMENT = 1
 
-	var sloc uint
+	var sloc uint64
 	var mode int = NORMAL /* NORMAL, INSTRING, INMULTISTRING, or INCOMMENT */
 	var commentType int   /* BLOCK_COMMENT or TRAILING_COMMENT */
+	var depth int         /* nesting depth of block comments */
+	var spliced bool      /* was the last character a splicing backslash? */
+	var bol bool = true   /* no code seen yet on this line? */
+	var directive bool    /* is this line a preprocessor directive? */
+	var prev byte         /* previous character */
+	var quote byte        /* delimiter of the string we're in */
+	var terminated bool   /* statement terminator or brace on this line? */
+	var body []byte       /* string literal so far, for -embedded-sql */
+	var delimiter = ";"   /* SQL statement terminator, as set by DELIMITER */
+	var open bool         /* code since the last statement ended? */
+	var api apiScanner    /* declarations seen, for -api */
 	var startline uint
 
 	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
@@ -984,19 +2041,86 @@ func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint
 
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
+	ctx.preproc = 0
+	ctx.effective = 0
+	ctx.api = 0
+	ctx.logical = true
+	api.outside = true
+	rules := ctx.rules()
+	preproc := countPreproc && rules.CPreprocessed[syntax.name]
+	directives := (preproc || countEffective || countAPI) && rules.CPreprocessed[syntax.name]
+	regions := noCountRegions && rules.RegionMarked[syntax.name]
 
 	for {
 		c, err := ctx.getachar()
 		if err == io.EOF {
 			break
 		}
+		instring := (mode == INSTRING || mode == INMULTISTRING)
+
+		if regions && mode == NORMAL && bol && c == '#' {
+			// A folding marker, and the label after it, is
+			// taken for a winged comment.
+			if s, _ := ctx.rc.Peek(32); regionMarker.Match(s) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				commentType = TRAILING_COMMENT
+				prev = c
+				continue
+			}
+		}
+		if rules.SQLQuoting[syntax.name] && mode == NORMAL && bol && !isspace(c) {
+			// Client commands aren't SQL and don't count, but
+			// a GO ends the batch and any statement left open.
+			s, _ := ctx.rc.Peek(64)
+			m := sqlClientCommand.FindSubmatch(append([]byte{c}, s...))
+			if m != nil && (m[3] != nil || syntax.name == "t-sql") {
+				if m[3] != nil {
+					delimiter = string(m[3])
+				} else if open {
+					ctx.effective++
+					open = false
+				}
+				mode = INCOMMENT
+				commentType = TRAILING_COMMENT
+				prev = c
+				continue
+			}
+		}
+		if directives && mode == NORMAL && bol && c == '#' {
+			directive = true
+		}
+		if mode == NORMAL && !isspace(c) {
+			bol = false
+		}
+		if mode == NORMAL && countAPI {
+			api.see(c)
+		}
+		if mode == NORMAL && (c == ';' || c == '{' || c == '}') {
+			terminated = true
+		} else if mode == NORMAL && delimiter != ";" && ctx.opens(c, delimiter) {
+			ctx.nonblank = true
+			terminated = true
+			prev = c
+			continue
+		}
 
 		if mode == NORMAL {
-			if !ctx.lexfile && c == '"' {
+			// Multistring check goes first so that a triple
+			// quote isn't mistaken for an ordinary string.
+			if (syntax.multistring != "") && (c == syntax.multistring[0]) && ctx.consume([]byte(syntax.multistring[1:])) {
+				// The opening delimiter is itself code, even
+				// when nothing else precedes it on the line.
+				ctx.nonblank = true
+				mode = INMULTISTRING
+				startline = ctx.lineNumber
+			} else if !ctx.lexfile && (c == '"' || (c == '\'' && (rules.SQLQuoting[syntax.name] || rules.SingleQuoted[syntax.name]))) {
 				ctx.nonblank = true
 				mode = INSTRING
+				quote = c
 				startline = ctx.lineNumber
-			} else if !ctx.lexfile && c == '\'' {
+			} else if !ctx.lexfile && c == '\'' && !rules.NoCharLiterals[syntax.name] {
 				/* Consume single-character 'xxxx' values */
 				ctx.nonblank = true
 				c, err = ctx.getachar()
@@ -1009,19 +2133,20 @@ func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint
 						break
 					}
 				}
-			} else if (c == syntax.commentleader[0]) && (ctx.ispeek(syntax.commentleader[1])) {
-				c, err = ctx.getachar()
+			} else if ctx.opens(c, syntax.commentleader) {
 				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
 				commentType = BLOCK_COMMENT
+				depth = 1
 				startline = ctx.lineNumber
-			} else if (syntax.eolcomment != "") && c == syntax.eolcomment[0] && (len(syntax.eolcomment) > 1 && ctx.ispeek(syntax.eolcomment[1])) {
-				c, _ = ctx.getachar()
+			} else if ctx.opens(c, syntax.eolcomment) {
 				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
 				commentType = TRAILING_COMMENT
 				startline = ctx.lineNumber
-			} else if (syntax.multistring != "") && (c == syntax.multistring[0]) {
-				mode = INMULTISTRING
-				startline = ctx.lineNumber
+			} else if noCountBraces && (c == '{' || c == '}' || c == ';') {
+				// Structural punctuation alone doesn't
+				// make a line count.
 			} else if !isspace(c) {
 				ctx.nonblank = true
 			}
@@ -1034,9 +2159,14 @@ func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint
 			if !isspace(c) {
 				ctx.nonblank = true
 			}
-			if c == '"' {
+			if quote == '\'' && rules.SQLQuoting[syntax.name] {
+				// SQL style: no escapes but a doubled quote
+				if c == '\'' && !ctx.consume([]byte("'")) {
+					mode = NORMAL
+				}
+			} else if c == quote {
 				mode = NORMAL
-			} else if (c == '\\') && (ctx.ispeek('"') || ctx.ispeek('\\')) {
+			} else if (c == '\\') && (ctx.ispeek(quote) || ctx.ispeek('\\')) {
 				c, _ = ctx.getachar()
 			} else if (c == '\\') && ctx.ispeek('\n') {
 				c, _ = ctx.getachar()
@@ -1059,32 +2189,78 @@ func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint
 			if !isspace(c) {
 				ctx.nonblank = true
 			}
-			if c == syntax.multistring[0] {
+			if (c == syntax.multistring[0]) && ctx.consume([]byte(syntax.multistring[1:])) {
 				mode = NORMAL
 			}
 		} else { /* INCOMMENT mode */
-			if (c == '\n') && (commentType == TRAILING_COMMENT) {
+			if (c == '\n') && (commentType == TRAILING_COMMENT) && !spliced {
 				mode = NORMAL
 			}
-			if (commentType == BLOCK_COMMENT) && (c == syntax.commenttrailer[0]) && ctx.ispeek(syntax.commenttrailer[1]) {
-				c, _ = ctx.getachar()
+			spliced = rules.CPreprocessed[syntax.name] && (c == '\\') && ctx.ispeek('\n')
+			if (commentType == TRAILING_COMMENT) && rules.EOLClosable[syntax.name] && ctx.opens(c, syntax.eolcomment) {
 				mode = NORMAL
+			} else if (commentType == BLOCK_COMMENT) && syntax.nestcomments && ctx.opens(c, syntax.commentleader) {
+				depth++
+			} else if (commentType == BLOCK_COMMENT) && ctx.opens(c, syntax.commenttrailer) {
+				depth--
+				if depth == 0 {
+					mode = NORMAL
+				}
+			}
+		}
+		if ctx.sqlLines != nil {
+			if instring && mode == NORMAL {
+				ctx.embeddedSQL(body, startline, ctx.lineNumber)
+			} else if instring {
+				body = append(body, c)
+			} else if mode == INSTRING || mode == INMULTISTRING {
+				body = body[:0]
 			}
 		}
 		if c == '\n' {
+			if countAPI && api.endLine(ctx.nonblank, directive) {
+				ctx.api++
+			}
 			if ctx.nonblank {
 				sloc++
+				ctx.countLine(ctx.lineNumber)
+				// A wrapped statement counts once, on the
+				// line where it ends.
+				if terminated || (directive && prev != '\\') {
+					ctx.effective++
+				}
+				open = !terminated
+			}
+			if directive {
+				if preproc {
+					ctx.preproc++
+				}
+				// A directive continues past a backslash-newline.
+				directive = (prev == '\\')
 			}
+			bol = true
+			terminated = false
 			ctx.nonblank = false
 			if ctx.consume([]byte("%")) {
 				ctx.lexfile = true
 				ctx.nonblank = true
 			}
 		}
+		prev = c
 	}
 	/* We're done with the file.  Handle EOF-without-EOL. */
+	if countAPI && api.endLine(ctx.nonblank, directive) {
+		ctx.api++
+	}
 	if ctx.nonblank {
 		sloc++
+		ctx.countLine(ctx.lineNumber)
+		if terminated || directive {
+			ctx.effective++
+		}
+	}
+	if directive && preproc {
+		ctx.preproc++
 	}
 	ctx.nonblank = false
 	if (mode == INCOMMENT) && (commentType == TRAILING_COMMENT) {
@@ -1092,21 +2268,43 @@ func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) uint
 	}
 
 	if mode == INCOMMENT {
-		log.Printf("%q, line %d: ERROR - terminated in comment beginning here\n",
-			path, startline)
+		ctx.unterminated(path, startline, "comment")
 	} else if mode == INSTRING {
-		log.Printf("%q, line %d: ERROR - terminated in string beginning here\n",
-			path, startline)
+		ctx.unterminated(path, startline, "string")
 	}
 
 	return sloc
 }
 
+// eolCommentIndex - locate a winged-comment leader that is not inside
+// a double-quoted string, or return -1.  Strings are only tracked within
+// the line; this keeps "hello;world" in Lisp from looking like a comment.
+func eolCommentIndex(line []byte, eolcomment string) int {
+	if eolcomment == "" {
+		return -1
+	}
+	instring := false
+	for i := 0; i < len(line); i++ {
+		if instring {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				instring = false
+			}
+		} else if line[i] == '"' {
+			instring = true
+		} else if bytes.HasPrefix(line[i:], []byte(eolcomment)) {
+			return i
+		}
+	}
+	return -1
+}
+
 // genericCounter - count SLOC in a generic language.
 func genericCounter(ctx *countContext,
 	path string, eolcomment string,
-	verifier func(*countContext, string) bool) uint {
-	var sloc uint
+	verifier func(*countContext, string) bool) uint64 {
+	var sloc uint64
 
 	if verifier != nil && !verifier(ctx, path) {
 		return 0
@@ -1114,44 +2312,65 @@ func genericCounter(ctx *countContext,
 
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
 
 	for ctx.munchline() {
-		i := bytes.Index(ctx.line, []byte(eolcomment))
+		i := eolCommentIndex(ctx.line, eolcomment)
 		if i > -1 {
 			ctx.line = ctx.line[:i]
+			ctx.commented(ctx.lineNumber - 1)
 		}
 		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
 		if len(ctx.line) > 0 {
 			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
 		}
 	}
 
 	return sloc
 }
 
-func pythonCounter(ctx *countContext, path string) uint {
-	var sloc uint
-	var isintriple bool  // A triple-quote is in effect.
-	var isincomment bool // We are in a multiline (triple-quoted) comment.
+func pythonCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var isintriple bool      // A triple-quote is in effect.
+	var isincomment bool     // We are in a multiline (triple-quoted) comment.
+	var strs pythonStrings   // String literals, for -embedded-sql.
+	var scopes []pythonScope // Enclosing definitions, for -api.
 
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
+	ctx.api = 0
 
 	tripleBoundary := func(line []byte) bool { return bytes.Contains(line, []byte(dt)) || bytes.Contains(line, []byte(st)) }
 	for ctx.munchline() {
+		// Only a line that starts outside a string can declare.
+		declares := countAPI && !isintriple
+		indent := indentWidth(string(ctx.line[:len(ctx.line)-len(bytes.TrimLeft(ctx.line, " \t"))]), 8)
+		if ctx.sqlLines != nil {
+			strs.scan(ctx, ctx.line)
+		}
+
 		// Delete trailing comments
 		i := bytes.Index(ctx.line, []byte("#"))
 		if i > -1 {
 			ctx.line = ctx.line[:i]
+			ctx.commented(ctx.lineNumber - 1)
 		}
 
-		if !isintriple { // Normal case:
+		if !isintriple && docstringsAsCode {
+			// Keep something of one-line docstrings to count.
+			ctx.line = dtriple.ReplaceAllLiteral(ctx.line, []byte("x"))
+			ctx.line = striple.ReplaceAllLiteral(ctx.line, []byte("x"))
+		} else if !isintriple {
 			// Ignore triple-quotes that begin & end on the ctx.line.
 			ctx.line = dtriple.ReplaceAllLiteral(ctx.line, []byte(""))
 			ctx.line = striple.ReplaceAllLiteral(ctx.line, []byte(""))
 			// Delete lonely strings starting on BOL.
 			ctx.line = dlonely.ReplaceAllLiteral(ctx.line, []byte(""))
 			ctx.line = slonely.ReplaceAllLiteral(ctx.line, []byte(""))
+		}
+		if !isintriple {
 			// Delete trailing comments
 			i := bytes.Index(ctx.line, []byte("#"))
 			if i > -1 {
@@ -1161,8 +2380,11 @@ func pythonCounter(ctx *countContext, path string) uint {
 			if tripleBoundary(ctx.line) {
 				isintriple = true
 				ctx.line = bytes.Trim(ctx.line, " \t\r\n")
-				// It's a comment if at BOL.
-				if bytes.HasPrefix(ctx.line, []byte(dt)) || bytes.HasPrefix(ctx.line, []byte(st)) {
+				// It's a comment if at BOL, unless docstrings
+				// are wanted as code.
+				if docstringsAsCode {
+					// Count it like a string.
+				} else if bytes.HasPrefix(ctx.line, []byte(dt)) || bytes.HasPrefix(ctx.line, []byte(st)) {
 					isincomment = true
 				}
 			}
@@ -1192,6 +2414,14 @@ func pythonCounter(ctx *countContext, path string) uint {
 		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
 		if !isincomment && len(ctx.line) > 0 {
 			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+			if declares {
+				var decl bool
+				scopes, decl = pythonAPI(scopes, indent, ctx.line)
+				if decl {
+					ctx.api++
+				}
+			}
 		}
 	}
 
@@ -1208,24 +2438,36 @@ func pythonCounter(ctx *countContext, path string) uint {
 // Note that = followed by space is NOT a perlpod.
 // Although we ignore everything after __END__ in a file,
 // we will count everything after __DATA__; there's arguments for counting
-// and for not counting __DATA__.
+// and for not counting __DATA__.  If splitData is on, the nonblank lines
+// after __DATA__ are returned as a separate data count instead.
 //
 // What's worse, "here" documents must be COUNTED AS CODE, even if
 // they're FORMATTED AS A PERLPOD.  Surely no one would do this, right?
 // Sigh... it can happen. See perl5.005_03/pod/splitpod.
-func perlCounter(ctx *countContext, path string) uint {
-	var sloc uint
+func perlCounter(ctx *countContext, path string) (uint64, uint64) {
+	var sloc uint64
+	var data uint64
 	var heredoc string
 	var isinpod bool
+	var isindata bool
 
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
 
 	for ctx.munchline() {
+		if isindata {
+			if len(bytes.TrimSpace(ctx.line)) > 0 {
+				data++
+			}
+			continue
+		}
+
 		// Delete trailing comments
 		i := bytes.Index(ctx.line, []byte("#"))
 		if i > -1 {
 			ctx.line = ctx.line[:i]
+			ctx.commented(ctx.lineNumber - 1)
 		}
 
 		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
@@ -1253,27 +2495,158 @@ func perlCounter(ctx *countContext, path string) uint {
 		} else if bytes.HasPrefix(ctx.line, []byte("__END__")) {
 			// Stop processing this file on __END__.
 			break
+		} else if splitData && len(heredoc) == 0 && bytes.HasPrefix(ctx.line, []byte("__DATA__")) {
+			isindata = true
 		}
 		if !isinpod && len(ctx.line) > 0 {
 			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
 		}
 	}
 
-	return sloc
+	return sloc, data
 }
 
-// pascalCounter - Handle lanuages like Pascal and Modula 3
-func pascalCounter(ctx *countContext, path string, syntax pascalLike) uint {
-	var sloc uint
-	var mode int = NORMAL /* NORMAL, or INCOMMENT */
+// rakuCounter - count SLOC in Raku, the language once called Perl 6
+//
+// Raku keeps Perl's # winged comments but adds embedded comments, a #
+// and a backtick followed by an opening bracket, which run to the
+// matching closer and so may span lines or sit in the middle of one.
+// The bracket may be doubled, as in #`[[ ... ]], and brackets of the
+// same kind nest inside.  Declarator comments #| and #= take brackets
+// the same way.  Pod is Pod6, not Perl's: =begin NAME runs to the
+// matching =end NAME, any other =directive to the next blank line, and
+// =finish ends the code.
+func rakuCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var pod []string  // Names of the delimited Pod blocks we're in
+	var inpara bool   // In an abbreviated Pod block?
+	var opener []byte // Opening bracket(s) of the embedded comment we're in
+	var closer []byte // and the closer(s) that will end it
+	var depth int     // Nesting depth of embedded comments
+	var quote byte    // Delimiter of the string we're in
 	var startline uint
 
-	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
-		return 0
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	rakuClosers := map[byte]byte{'(': ')', '[': ']', '{': '}', '<': '>'}
+	for ctx.munchline() {
+		trimmed := bytes.TrimSpace(ctx.line)
+		if inpara {
+			if len(trimmed) > 0 {
+				continue
+			}
+			inpara = false
+		}
+		if depth == 0 && quote == 0 && podheader.Match(trimmed) {
+			fields := strings.Fields(string(trimmed[1:]))
+			directive, name := fields[0], ""
+			if len(fields) > 1 {
+				name = fields[1]
+			}
+			if directive == "finish" || directive == "END" {
+				break
+			} else if directive == "begin" {
+				pod = append(pod, name)
+			} else if directive == "end" {
+				if len(pod) == 0 || pod[len(pod)-1] != name {
+					log.Printf("%q, %d: =end %s without =begin\n",
+						path, ctx.lineNumber, name)
+				} else {
+					pod = pod[:len(pod)-1]
+				}
+			} else if len(pod) == 0 {
+				inpara = true
+			}
+			continue
+		}
+		if len(pod) > 0 {
+			continue
+		}
+
+		code := false
+		line := ctx.line
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case depth > 0:
+				if bytes.HasPrefix(line[i:], closer) {
+					depth--
+					i += len(closer) - 1
+				} else if bytes.HasPrefix(line[i:], opener) {
+					depth++
+					i += len(opener) - 1
+				}
+			case quote != 0:
+				code = true
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '#':
+				ctx.commented(ctx.lineNumber - 1)
+				j := i + 1
+				if j < len(line) && (line[j] == '`' || line[j] == '|' || line[j] == '=') {
+					j++
+				}
+				if j == i+1 || j >= len(line) || rakuClosers[line[j]] == 0 {
+					break scan
+				}
+				n := 1
+				for j+n < len(line) && line[j+n] == line[j] {
+					n++
+				}
+				opener = bytes.Repeat([]byte{line[j]}, n)
+				closer = bytes.Repeat([]byte{rakuClosers[line[j]]}, n)
+				depth = 1
+				startline = ctx.lineNumber
+				i = j + n - 1
+			case c == '"' || (c == '\'' && !(i > 0 && isAlnum(line[i-1]) && i+1 < len(line) && isAlnum(line[i+1]))):
+				// An apostrophe between letters is part of a
+				// name, as in isn't, not a quote.
+				quote = c
+				startline = ctx.lineNumber
+				code = true
+			case !isspace(c):
+				code = true
+			}
+		}
+		if code {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	if depth > 0 {
+		ctx.unterminated(path, startline, "comment")
+	} else if quote != 0 {
+		ctx.unterminated(path, startline, "string")
 	}
 
+	return sloc
+}
+
+// coffeeCounter - count SLOC in CoffeeScript and its relatives
+//
+// These have # winged comments, a block comment delimited by the
+// given leader and trailer (### in CoffeeScript, /* */ in LiveScript),
+// and triple-quoted heredoc strings that may contain anything.  A #
+// inside a string is not a comment; this matters because of #{}
+// interpolation.
+func coffeeCounter(ctx *countContext, path string, leader string, trailer string) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, INMULTISTRING, or INCOMMENT */
+	var inblock bool      /* is the comment we're in a block comment? */
+	var quote byte        /* delimiter of the string we're in */
+	var startline uint
+
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
 
 	for {
 		c, err := ctx.getachar()
@@ -1282,193 +2655,2804 @@ func pascalCounter(ctx *countContext, path string, syntax pascalLike) uint {
 		}
 
 		if mode == NORMAL {
-			if syntax.bracketcomments && c == '{' {
+			if c == leader[0] && ctx.consume([]byte(leader[1:])) && !ctx.ispeek(leader[0]) {
 				mode = INCOMMENT
-			} else if (c == '(') && ctx.ispeek('*') {
-				c, _ = ctx.getachar()
+				ctx.commented(ctx.lineNumber)
+				inblock = true
+				startline = ctx.lineNumber
+			} else if c == '#' {
 				mode = INCOMMENT
-			} else if !isspace(c) {
+				ctx.commented(ctx.lineNumber)
+				inblock = false
+			} else if c == '"' || c == '\'' {
 				ctx.nonblank = true
-			} else if c == '\n' {
-				if ctx.nonblank {
-					sloc++
+				quote = c
+				startline = ctx.lineNumber
+				if ctx.consume([]byte{c, c}) {
+					mode = INMULTISTRING
+				} else {
+					mode = INSTRING
 				}
-				ctx.nonblank = false
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+		} else if mode == INSTRING || mode == INMULTISTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '\\' {
+				c, _ = ctx.getachar()
+			} else if c == quote && (mode == INSTRING || ctx.consume([]byte{c, c})) {
+				mode = NORMAL
 			}
 		} else { /* INCOMMENT mode */
-			if syntax.bracketcomments && c == '}' {
+			if !inblock && c == '\n' {
 				mode = NORMAL
-			} else if (c == '*') && ctx.ispeek(')') {
-				_, _ = ctx.getachar()
+			} else if inblock && c == trailer[0] && ctx.consume([]byte(trailer[1:])) {
 				mode = NORMAL
 			}
 		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
 	}
 	/* We're done with the file.  Handle EOF-without-EOL. */
 	if ctx.nonblank {
 		sloc++
+		ctx.countLine(ctx.lineNumber)
 	}
 	ctx.nonblank = false
 
-	if mode == INCOMMENT {
-		log.Printf("%q, line %d: ERROR - terminated in comment beginning here.\n",
-			path, startline)
-	} else if mode == INSTRING {
-		log.Printf("%q, line %d: ERROR - terminated in string beginning here.\n",
-			path, startline)
+	if mode == INCOMMENT && inblock {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING || mode == INMULTISTRING {
+		ctx.unterminated(path, startline, "string")
 	}
 
 	return sloc
 }
 
-func fortranCounter(ctx *countContext, path string, syntax fortranLike) uint {
-	var sloc uint
+// fsharpCounter - count SLOC in F#
+//
+// F# has // winged comments and (* *) block comments, which nest.
+// The operator section (*) is not a comment.  Strings come in three
+// kinds: ordinary "..." with backslash escapes, verbatim @"..." in
+// which a doubled quote stands for one, and triple-quoted """...""",
+// which may contain anything.  A ' may open a character literal or
+// lead a type variable like 'T, so only the literal is skipped.
+func fsharpCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, INMULTISTRING, or INCOMMENT */
+	var verbatim bool     /* is the string we're in a verbatim string? */
+	var depth int         /* nesting depth of block comments, 0 if winged */
+	var startline uint
 
 	ctx.setup(path)
 	defer ctx.teardown()
+	ctx.skipHashbang()
 
-	for ctx.munchline() {
-		if !(syntax.comment.Match(ctx.line) && !syntax.nocomment.Match(ctx.line)) {
-			sloc++
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
 		}
-	}
-	return sloc
-}
-
-// Generic - recognize lots of languages with generic syntax
-func Generic(ctx *countContext, path string) SourceStat {
-	var stat SourceStat
 
-	autofilter := func(eolcomment string) bool {
-		if wasGeneratedAutomatically(ctx, path, eolcomment) {
-			if debug > 0 {
-				fmt.Printf("automatic generation filter failed: %s\n", path)
+		if mode == NORMAL {
+			if c == '(' && ctx.consume([]byte("*)")) {
+				ctx.nonblank = true
+			} else if c == '(' && ctx.consume([]byte("*")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth = 1
+				startline = ctx.lineNumber
+			} else if c == '/' && ctx.consume([]byte("/")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth = 0
+			} else if c == '"' || (c == '@' && ctx.consume([]byte("\""))) {
+				ctx.nonblank = true
+				startline = ctx.lineNumber
+				verbatim = (c == '@')
+				if !verbatim && ctx.consume([]byte("\"\"")) {
+					mode = INMULTISTRING
+				} else {
+					mode = INSTRING
+				}
+			} else if c == '\'' {
+				ctx.nonblank = true
+				/* Consume 'x' and '\x' character literals */
+				if ctx.consume([]byte("\\")) {
+					c, err = ctx.getachar()
+					for c != '\'' && c != '\n' && err != io.EOF {
+						c, err = ctx.getachar()
+					}
+				} else if s, err := ctx.rc.Peek(2); err == nil && s[0] != '\n' && s[1] == '\'' {
+					ctx.rc.Discard(2)
+				}
+			} else if !isspace(c) {
+				ctx.nonblank = true
 			}
-			return true
-		}
-		if debug > 0 {
-			fmt.Printf("automatic generation filter passed: %s\n", path)
-		}
-		return false
-	}
-
-	for i := range genericLanguages {
-		lang := genericLanguages[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			if autofilter(lang.eolcomment) {
-				return stat
-			} else if len(lang.commentleader) > 0 {
-				stat.SLOC = cFamilyCounter(ctx, path, lang)
-			} else {
-				stat.SLOC = genericCounter(ctx, path,
-					lang.eolcomment, lang.verifier)
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if verbatim && c == '"' {
+				if !ctx.consume([]byte("\"")) {
+					mode = NORMAL
+				}
+			} else if !verbatim && c == '\\' {
+				c, _ = ctx.getachar()
+			} else if c == '"' {
+				mode = NORMAL
+			}
+		} else if mode == INMULTISTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '"' && ctx.consume([]byte("\"\"")) {
+				mode = NORMAL
+			}
+		} else { /* INCOMMENT mode */
+			if depth == 0 {
+				if c == '\n' {
+					mode = NORMAL
+				}
+			} else if c == '(' && ctx.consume([]byte("*)")) {
+				// Operator section, not a nested comment
+			} else if c == '(' && ctx.consume([]byte("*")) {
+				depth++
+			} else if c == '*' && ctx.consume([]byte(")")) {
+				depth--
+				if depth == 0 {
+					mode = NORMAL
+				}
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT && depth > 0 {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING || mode == INMULTISTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// juliaCounter - count SLOC in Julia
+//
+// Julia has # winged comments and #= =# block comments, which nest.
+// Strings are "...", """...""", and `...` commands, any of which may
+// span lines and interpolate $(...) expressions that can hold strings
+// and comments of their own, so the lexer keeps a stack of the strings
+// it has stepped out of.  A ' opens a character literal unless it
+// follows a value, in which case it is the transpose operator.
+func juliaCounter(ctx *countContext, path string) uint64 {
+	type outer struct {
+		mode   int
+		quote  string
+		parens int
+	}
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var depth int         /* nesting depth of block comments, 0 if winged */
+	var quote string      /* delimiter that will end the string we're in */
+	var parens int        /* open parentheses in the current $(...) */
+	var stack []outer     /* strings we're interpolating into */
+	var prev byte         /* last code character, to tell transposes */
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if c == '#' && ctx.consume([]byte("=")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth = 1
+				startline = ctx.lineNumber
+			} else if c == '#' {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth = 0
+			} else if c == '"' || c == '`' {
+				ctx.nonblank = true
+				mode = INSTRING
+				quote = string(c)
+				if ctx.consume([]byte{c, c}) {
+					quote = strings.Repeat(quote, 3)
+				}
+				startline = ctx.lineNumber
+			} else if c == '\'' && !(isAlnum(prev) || bytes.IndexByte([]byte("_!)]}'."), prev) > -1) {
+				ctx.nonblank = true
+				/* Consume 'x' and '\x' character literals */
+				for {
+					c, err = ctx.getachar()
+					if c == '\\' {
+						c, err = ctx.getachar()
+					} else if c == '\'' || c == '\n' || err == io.EOF {
+						break
+					}
+				}
+			} else if c == '(' {
+				ctx.nonblank = true
+				parens++
+			} else if c == ')' && parens == 0 && len(stack) > 0 {
+				// End of an interpolation; back into the string.
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				mode, quote, parens = top.mode, top.quote, top.parens
+			} else if c == ')' {
+				ctx.nonblank = true
+				parens--
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if mode == NORMAL && !isspace(c) {
+				prev = c
+			}
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '\\' && !ctx.ispeek('\n') {
+				c, _ = ctx.getachar()
+			} else if c == '$' && ctx.consume([]byte("(")) {
+				stack = append(stack, outer{mode, quote, parens})
+				mode, parens = NORMAL, 0
+			} else if c == quote[0] && (len(quote) == 1 || ctx.consume([]byte(quote[1:]))) {
+				mode = NORMAL
+				prev = c
+			}
+		} else { /* INCOMMENT mode */
+			if depth == 0 {
+				if c == '\n' {
+					mode = NORMAL
+				}
+			} else if c == '#' && ctx.consume([]byte("=")) {
+				depth++
+			} else if c == '=' && ctx.consume([]byte("#")) {
+				depth--
+				if depth == 0 {
+					mode = NORMAL
+				}
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT && depth > 0 {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING || len(stack) > 0 {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// dCounter - count SLOC in D
+//
+// D has // winged comments, /* */ block comments, and /+ +/ block
+// comments, which nest, so that code holding /* */ comments can be
+// commented out whole.  Of its strings, "..." has backslash escapes,
+// while `...` and r"..." are WYSIWYG, taking everything literally.
+// A delimited string q"(...)" ends at the bracket matching the one
+// it opens with, q"/.../" at the next /", and q"EOS ... EOS" at a
+// line beginning with EOS".  All of these may run over several lines.
+// Token strings, q{...}, hold only D tokens, so they are lexed as code.
+func dCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var depth int         /* nesting depth of /+ +/ comments */
+	var block bool        /* in a slash-star comment, not a winged one? */
+	var closer string     /* what ends the string we're in */
+	var escapes bool      /* do backslashes escape in that string? */
+	var heredoc bool      /* does the closer have to begin a line? */
+	var opener byte       /* bracket that nests in a delimited string */
+	var brackets int      /* nesting depth of those brackets */
+	var prev byte = '\n'
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if c == '/' && ctx.consume([]byte("/")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth, block = 0, false
+			} else if c == '/' && ctx.consume([]byte("*")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth, block = 0, true
+				startline = ctx.lineNumber
+			} else if c == '/' && ctx.consume([]byte("+")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth, block = 1, false
+				startline = ctx.lineNumber
+			} else if c == '"' || c == '`' || (c == 'r' && !isAlnum(prev) && ctx.consume([]byte("\""))) {
+				ctx.nonblank = true
+				mode = INSTRING
+				startline = ctx.lineNumber
+				closer, escapes, heredoc, opener = "\"", c == '"', false, 0
+				if c == '`' {
+					closer = "`"
+				}
+			} else if c == 'q' && !isAlnum(prev) && ctx.consume([]byte("\"")) {
+				ctx.nonblank = true
+				mode = INSTRING
+				startline = ctx.lineNumber
+				escapes, heredoc, opener = false, false, 0
+				d, _ := ctx.getachar()
+				if i := strings.IndexByte("([{<", d); i > -1 {
+					opener, brackets = d, 1
+					closer = string(")]}>"[i]) + "\""
+				} else if isAlnum(d) {
+					// An identifier, ending the string when
+					// it begins a line
+					ident := []byte{d}
+					for s, err := ctx.rc.Peek(1); err == nil && isAlnum(s[0]); s, err = ctx.rc.Peek(1) {
+						c, _ = ctx.getachar()
+						ident = append(ident, c)
+					}
+					closer, heredoc = string(ident)+"\"", true
+				} else {
+					closer = string(d) + "\""
+				}
+				c = d
+			} else if c == '\'' {
+				ctx.nonblank = true
+				/* Consume 'x' and '\x' character literals */
+				if ctx.consume([]byte("\\")) {
+					c, err = ctx.getachar()
+					for c != '\'' && c != '\n' && err != io.EOF {
+						c, err = ctx.getachar()
+					}
+				} else if s, err := ctx.rc.Peek(2); err == nil && s[0] != '\n' && s[1] == '\'' {
+					ctx.rc.Discard(2)
+				}
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if escapes && c == '\\' {
+				c, _ = ctx.getachar()
+			} else if opener != 0 && c == opener {
+				brackets++
+			} else if opener != 0 && c == closer[0] {
+				brackets--
+				if brackets == 0 && ctx.consume([]byte("\"")) {
+					mode = NORMAL
+				}
+			} else if opener == 0 && (!heredoc || prev == '\n') && ctx.opens(c, closer) {
+				mode = NORMAL
+			}
+		} else { /* INCOMMENT mode */
+			if depth == 0 && !block {
+				if c == '\n' {
+					mode = NORMAL
+				}
+			} else if block {
+				if c == '*' && ctx.consume([]byte("/")) {
+					mode = NORMAL
+				}
+			} else if c == '/' && ctx.consume([]byte("+")) {
+				depth++
+			} else if c == '+' && ctx.consume([]byte("/")) {
+				depth--
+				if depth == 0 {
+					mode = NORMAL
+				}
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+		prev = c
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT && (block || depth > 0) {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// adaCounter - count SLOC in Ada
+//
+// Ada has only -- winged comments.  Its strings are "..." with a
+// doubled quote standing for one and no backslash escapes, and a '
+// may open a character literal such as '"' or '-', or introduce an
+// attribute, as in Buffer'Length.  Neither kind of literal can run
+// past the end of its line.
+func adaCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		line := ctx.line
+		for i := 0; i < len(line); i++ {
+			if line[i] == '"' {
+				for i++; i < len(line); i++ {
+					if line[i] != '"' {
+						continue
+					} else if i+1 < len(line) && line[i+1] == '"' {
+						i++ // a doubled quote stands for one
+					} else {
+						break
+					}
+				}
+			} else if line[i] == '\'' && i+2 < len(line) && line[i+2] == '\'' && (i == 0 || !(isAlnum(line[i-1]) || line[i-1] == ')')) {
+				i += 2
+			} else if line[i] == '-' && i+1 < len(line) && line[i+1] == '-' {
+				line = line[:i]
+				ctx.commented(ctx.lineNumber - 1)
+				break
+			}
+		}
+		if len(bytes.Trim(line, " \t\r\n")) > 0 {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	return sloc
+}
+
+// abapCounter - count SLOC in ABAP
+//
+// ABAP turns the C conventions around much as Smalltalk does: a "
+// begins a comment that runs to the end of the line, and a * in the
+// first column makes the whole line a comment.  Literals are '...'
+// text fields and `...` strings, in which a doubled delimiter stands
+// for one, and |...| string templates, in which a backslash escapes
+// the next character.  None of them can run past the end of its line.
+func abapCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		line := ctx.line
+		if len(line) > 0 && line[0] == '*' {
+			continue
+		}
+	scan:
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; c {
+			case '\'', '`':
+				for i++; i < len(line); i++ {
+					if line[i] != c {
+						continue
+					} else if i+1 < len(line) && line[i+1] == c {
+						i++ // a doubled delimiter stands for one
+					} else {
+						break
+					}
+				}
+			case '|':
+				for i++; i < len(line) && line[i] != '|'; i++ {
+					if line[i] == '\\' {
+						i++
+					}
+				}
+			case '"':
+				line = line[:i]
+				ctx.commented(ctx.lineNumber - 1)
+				break scan
+			}
+		}
+		if len(bytes.Trim(line, " \t\r\n")) > 0 {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	return sloc
+}
+
+// sasDataStatement - statements after which come lines of raw data,
+// ended by a line with a semicolon, or with four for the ones named
+// with a 4
+var sasDataStatement = regexp.MustCompile(`(?i)^\s*(datalines|cards|lines|parmcards)(4?)\s*$`)
+
+// sasCounter - count SLOC in SAS
+//
+// SAS has /* */ block comments, which don't nest, and statement
+// comments: a statement that begins with * (or %* in macro code) is a
+// comment running to the next semicolon, over as many lines as it
+// takes.  Strings are '...' or "..." with a doubled quote standing
+// for one, and may span lines.  The raw data after a DATALINES or
+// CARDS statement is code unless splitData asks for it apart; it
+// isn't lexed, since an apostrophe in it opens no string.
+func sasCounter(ctx *countContext, path string) (uint64, uint64) {
+	var sloc, data uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var statement bool    /* in a *...; comment rather than a block one? */
+	var start bool = true /* at the start of a statement? */
+	var text []byte       /* code of the statement so far */
+	var terminator []byte /* what ends the raw data we're in, if any */
+	var pending []byte    /* terminator of raw data starting next line */
+	var line []byte       /* the line of raw data being read */
+	var quote byte        /* delimiter of the string we're in */
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		if terminator != nil {
+			// Raw data goes a line at a time, through getachar
+			// like the rest so the line numbers stay in step.
+			line = line[:0]
+			c, err := ctx.getachar()
+			for err == nil && c != '\n' {
+				line = append(line, c)
+				c, err = ctx.getachar()
+			}
+			if bytes.Contains(line, terminator) {
+				terminator = nil
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			} else if len(bytes.TrimSpace(line)) == 0 {
+				// Blank lines are data, but don't count.
+			} else if splitData {
+				data++
+			} else {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if c == '/' && ctx.consume([]byte("*")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				statement = false
+				startline = ctx.lineNumber
+			} else if start && (c == '*' || (c == '%' && ctx.consume([]byte("*")))) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				statement = true
+				startline = ctx.lineNumber
+			} else if c == '\'' || c == '"' {
+				ctx.nonblank = true
+				mode = INSTRING
+				quote = c
+				startline = ctx.lineNumber
+				start = false
+				text = append(text, c)
+			} else if c == ';' {
+				ctx.nonblank = true
+				start = true
+				if m := sasDataStatement.FindSubmatch(text); m != nil {
+					pending = []byte(";")
+					if len(m[2]) > 0 {
+						pending = []byte(";;;;")
+					}
+				}
+				text = text[:0]
+			} else if !isspace(c) {
+				ctx.nonblank = true
+				start = false
+				text = append(text, c)
+			} else {
+				text = append(text, c)
+			}
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == quote && !ctx.consume([]byte{quote}) {
+				mode = NORMAL
+			}
+		} else { /* INCOMMENT mode */
+			if statement && c == ';' {
+				mode = NORMAL
+				start = true
+			} else if !statement && c == '*' && ctx.consume([]byte("/")) {
+				mode = NORMAL
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+			terminator, pending = pending, nil
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc, data
+}
+
+// smalltalkCounter - count SLOC in Smalltalk
+//
+// Smalltalk turns the usual conventions around: "..." is a comment
+// and '...' is a string, in which a doubled quote stands for one.
+// Neither nests.  A $ introduces a character literal, so $" and $'
+// open nothing.
+func smalltalkCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if c == '"' {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				startline = ctx.lineNumber
+			} else if c == '\'' {
+				ctx.nonblank = true
+				mode = INSTRING
+				startline = ctx.lineNumber
+			} else if c == '$' {
+				ctx.nonblank = true
+				/* Skip the character literal, unless it's a newline */
+				if !ctx.ispeek('\n') {
+					ctx.getachar()
+				}
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '\'' && !ctx.consume([]byte("'")) {
+				mode = NORMAL
+			}
+		} else { /* INCOMMENT mode */
+			if c == '"' {
+				mode = NORMAL
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// jsxCounter - count SLOC in React JSX
+//
+// Outside markup this is JavaScript, lexed by C-family rules.  A < where
+// an expression may begin opens an element.  Text inside an element is
+// content, not code, so // and /* there open no comments, but braces in
+// the text or in a tag escape back to JavaScript.  Elements nest and so
+// do escapes, so the lexer keeps a stack of contexts.
+func jsxCounter(ctx *countContext, path string) uint64 {
+	const (
+		inScript = iota // JavaScript; depth counts open braces
+		inTag           // between the < and > of a tag
+		inText          // element content; depth counts open elements
+	)
+	type frame struct {
+		kind    int
+		depth   int
+		closing bool // for a tag, is it a closing tag?
+	}
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || (c >= '0' && c <= '9') ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+	stack := []frame{{kind: inScript}}
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var quote byte        /* the quote that opened the string we're in */
+	var winged bool       /* is the comment we're in a winged one? */
+	var last byte = ';'   /* last non-space script character */
+	var prev byte         /* character before this one */
+	var word []byte       /* identifier being read, to catch return <div> */
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+		top := &stack[len(stack)-1]
+
+		if mode == INCOMMENT {
+			if winged && c == '\n' {
+				mode = NORMAL
+			} else if !winged && c == '*' && ctx.consume([]byte("/")) {
+				mode = NORMAL
+			}
+		} else if mode == INSTRING {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '\\' && top.kind == inScript && !ctx.ispeek('\n') {
+				ctx.getachar()
+			} else if c == quote {
+				mode = NORMAL
+			}
+		} else if top.kind == inText {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '{' {
+				stack = append(stack, frame{kind: inScript})
+			} else if c == '<' {
+				closing := ctx.consume([]byte("/"))
+				stack = append(stack, frame{kind: inTag, closing: closing})
+			}
+		} else if top.kind == inTag {
+			if !isspace(c) {
+				ctx.nonblank = true
+			}
+			if c == '"' || c == '\'' {
+				mode = INSTRING
+				quote = c
+				startline = ctx.lineNumber
+			} else if c == '{' {
+				stack = append(stack, frame{kind: inScript})
+			} else if c == '/' && ctx.consume([]byte(">")) {
+				stack = stack[:len(stack)-1]
+				last = ')'
+			} else if c == '>' {
+				closing := top.closing
+				stack = stack[:len(stack)-1]
+				top = &stack[len(stack)-1]
+				if closing && top.kind == inText {
+					top.depth--
+					if top.depth == 0 {
+						stack = stack[:len(stack)-1]
+						last = ')'
+					}
+				} else if !closing && top.kind == inText {
+					top.depth++
+				} else if !closing {
+					stack = append(stack, frame{kind: inText, depth: 1})
+				}
+			}
+		} else { /* inScript */
+			if c == '/' && ctx.consume([]byte("/")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				winged = true
+			} else if c == '/' && ctx.consume([]byte("*")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				winged = false
+				startline = ctx.lineNumber
+			} else if !isspace(c) {
+				ctx.nonblank = true
+				afterReturn := isIdent(last) && string(word) == "return"
+				if c == '"' || c == '\'' || c == '`' {
+					mode = INSTRING
+					quote = c
+					startline = ctx.lineNumber
+				} else if c == '<' && (afterReturn || strings.IndexByte("(,=:?[{}!&|>;", last) > -1) {
+					if s, err := ctx.rc.Peek(1); err == nil && (s[0] == '>' || isIdent(s[0])) {
+						stack = append(stack, frame{kind: inTag})
+					}
+				} else if c == '{' {
+					top.depth++
+				} else if c == '}' {
+					if top.depth > 0 {
+						top.depth--
+					} else if len(stack) > 1 {
+						stack = stack[:len(stack)-1]
+					}
+				}
+				if isIdent(c) {
+					if !isIdent(prev) {
+						word = word[:0]
+					}
+					word = append(word, c)
+				}
+				last = c
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+		prev = c
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT && !winged {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// iniCounter - count SLOC in INI-style configuration files
+//
+// Whole-line comments may be led with either ; or #, depending on
+// dialect.  Section headers are structure rather than settings, so
+// they count only if iniSections is set.
+func iniCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
+		if len(ctx.line) == 0 || ctx.line[0] == ';' || ctx.line[0] == '#' {
+			continue
+		}
+		if ctx.line[0] == '[' && !iniSections {
+			continue
+		}
+		sloc++
+		ctx.countLine(ctx.lineNumber - 1)
+	}
+
+	return sloc
+}
+
+// directiveCounter - count SLOC in Apache and Nginx configuration
+//
+// Both have # comments.  Apache recognizes them only at the start of
+// a line, so a # later on is part of a directive's arguments.  Nginx
+// recognizes one wherever a token could begin, as in "deny all; # why",
+// but not inside a quoted value, which may run over several lines.
+func directiveCounter(ctx *countContext, path string, trailing bool) uint64 {
+	var sloc uint64
+	var quote byte // quote character of the value we're in, if any
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		line := ctx.line
+		if quote == 0 {
+			line = bytes.TrimLeft(line, " \t")
+			if !trailing && len(line) > 0 && line[0] == '#' {
+				continue
+			}
+		}
+		code := false
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if quote != 0 {
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+			} else if trailing && c == '#' && (i == 0 || isspace(line[i-1]) || bytes.IndexByte([]byte(";{}"), line[i-1]) > -1) {
+				ctx.commented(ctx.lineNumber - 1)
+				break
+			} else if trailing && (c == '"' || c == '\'') {
+				quote = c
+				startline = ctx.lineNumber
+			}
+			if !isspace(c) {
+				code = true
+			}
+		}
+		if code {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	if quote != 0 {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// gherkinCounter - count SLOC in Cucumber feature files
+//
+// Keyword lines, steps, and table rows all count; whole-line # comments
+// don't.  Inside a """ or ``` doc string a # is just text.
+func gherkinCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var docstring []byte // delimiter of the doc string we're in
+
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
+		if docstring != nil {
+			if bytes.HasPrefix(ctx.line, docstring) {
+				docstring = nil
+			}
+		} else if bytes.HasPrefix(ctx.line, []byte(`"""`)) {
+			docstring = []byte(`"""`)
+		} else if bytes.HasPrefix(ctx.line, []byte("```")) {
+			docstring = []byte("```")
+		} else if len(ctx.line) == 0 || ctx.line[0] == '#' {
+			continue
+		}
+		if len(ctx.line) > 0 {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	return sloc
+}
+
+// texCounter - count SLOC in TeX and LaTeX
+//
+// A % begins a comment unless it is escaped, as in \%; but \\ is a
+// line break, so in \\% the % begins a comment after all.  What
+// counts is whether an odd number of backslashes comes before it.
+// Lines between \begin{comment} and \end{comment}, from the comment
+// package, are comments too.  In verbatim environments and in \verb
+// a % is just text.
+func texCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var incomment bool  // Inside a comment environment?
+	var verbatim string // End of the verbatim environment we're in
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		line := bytes.TrimSpace(ctx.line)
+		if incomment {
+			if bytes.HasPrefix(line, []byte("\\end{comment}")) {
+				incomment = false
+			}
+			continue
+		}
+		if verbatim != "" {
+			if bytes.Contains(line, []byte(verbatim)) {
+				verbatim = ""
+			}
+			if len(line) > 0 {
+				sloc++
+				ctx.countLine(ctx.lineNumber - 1)
+			}
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("\\begin{comment}")) {
+			incomment = true
+			continue
+		}
+		if m := texVerbatim.FindSubmatchIndex(line); m != nil {
+			end := "\\end{" + string(line[m[2]:m[3]]) + "}"
+			if !bytes.Contains(line[m[1]:], []byte(end)) {
+				verbatim = end
+			}
+		}
+
+		backslashes := 0
+	scan:
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; {
+			case c == '\\' && backslashes%2 == 0 && bytes.HasPrefix(line[i:], []byte("\\verb")) && i+6 < len(line) && !isAlnum(line[i+5]):
+				// Skip \verb|...|, whatever its delimiter.
+				delim := line[i+5]
+				if line[i+5] == '*' {
+					delim = line[i+6]
+					i++
+				}
+				if end := bytes.IndexByte(line[i+6:], delim); end > -1 {
+					i += 6 + end
+				} else {
+					i = len(line)
+				}
+				backslashes = 0
+			case c == '\\':
+				backslashes++
+			case c == '%' && backslashes%2 == 0:
+				line = bytes.TrimSpace(line[:i])
+				ctx.commented(ctx.lineNumber - 1)
+				break scan
+			default:
+				backslashes = 0
+			}
+		}
+		if len(line) > 0 {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	if incomment {
+		ctx.unterminated(path, 0, "comment environment")
+	}
+
+	return sloc
+}
+
+// xmlCounter - count nonblank lines of XML markup outside <!-- --> comments
+func xmlCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL or INCOMMENT */
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if c == '<' && ctx.consume([]byte("!--")) {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+		} else if c == '-' && ctx.consume([]byte("->")) {
+			mode = NORMAL
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				sloc++
+				ctx.countLine(ctx.lineNumber)
+			}
+			ctx.nonblank = false
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	return sloc
+}
+
+// rubyCounter - count SLOC in Ruby
+//
+// Ruby has # winged comments, and block comments between =begin and
+// =end lines starting in column 0.  Strings may span lines, and come
+// in several flavors besides quotes: %q(), %w[], and so on, where the
+// brackets nest.  Here documents are counted as code, as in Perl.
+func rubyCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var inblock bool   // Inside =begin/=end?
+	var heredoc string // Terminator of the here document we're in
+	var closer byte    // Delimiter that will end the string we're in
+	var opener byte    // Matching open bracket, if any
+	var depth int      // Bracket nesting depth within the string
+	var instring bool  // Are we in a string?
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	rubyClosers := map[byte]byte{'(': ')', '[': ']', '{': '}', '<': '>'}
+	for ctx.munchline() {
+		if inblock {
+			if bytes.HasPrefix(ctx.line, []byte("=end")) {
+				inblock = false
+			}
+			continue
+		}
+		if heredoc != "" {
+			if string(bytes.TrimSpace(ctx.line)) == heredoc {
+				heredoc = ""
+			}
+			if len(bytes.TrimSpace(ctx.line)) > 0 {
+				sloc++
+				ctx.countLine(ctx.lineNumber - 1)
+			}
+			continue
+		}
+		if !instring && bytes.HasPrefix(ctx.line, []byte("=begin")) {
+			inblock = true
+			continue
+		}
+		if !instring && bytes.HasPrefix(ctx.line, []byte("__END__")) {
+			break
+		}
+
+		line := ctx.line
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if instring {
+				if c == '\\' {
+					i++
+				} else if opener != 0 && c == opener {
+					depth++
+				} else if c == closer {
+					if depth > 0 {
+						depth--
+					} else {
+						instring = false
+					}
+				}
+			} else if c == '#' {
+				line = line[:i]
+				ctx.commented(ctx.lineNumber - 1)
+				break
+			} else if c == '"' || c == '\'' || c == '`' {
+				instring, closer, opener, depth = true, c, 0, 0
+				startline = ctx.lineNumber
+			} else if c == '%' && i+2 < len(line) && bytes.IndexByte([]byte("qQwWiIrsx"), line[i+1]) > -1 && !isspace(line[i+2]) && !isAlnum(line[i+2]) {
+				i += 2
+				instring, opener, depth = true, 0, 0
+				closer = line[i]
+				if rubyClosers[closer] != 0 {
+					opener, closer = closer, rubyClosers[closer]
+				}
+				startline = ctx.lineNumber
+			} else if c == '%' && i+1 < len(line) && rubyClosers[line[i+1]] != 0 {
+				i++
+				instring, opener, closer, depth = true, line[i], rubyClosers[line[i]], 0
+				startline = ctx.lineNumber
+			} else if c == '<' {
+				if m := heredocStart.FindSubmatch(line[i:]); m != nil {
+					heredoc = string(m[2])
+					i += len(m[0]) - 1
+				}
+			}
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	if inblock {
+		ctx.unterminated(path, 0, "=begin block")
+	} else if instring {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// hclCounter - count SLOC in HCL, the Terraform configuration language
+//
+// HCL has two winged-comment leaders, # and //, /* */ block comments,
+// and <<EOT here documents whose contents are counted as code.
+func hclCounter(ctx *countContext, path string) uint64 {
+	var sloc uint64
+	var inblock bool   // Inside a block comment?
+	var heredoc string // Terminator of the here document we're in
+	var startline uint
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		if heredoc != "" {
+			if string(bytes.TrimSpace(ctx.line)) == heredoc {
+				heredoc = ""
+			}
+			if len(bytes.TrimSpace(ctx.line)) > 0 {
+				sloc++
+				ctx.countLine(ctx.lineNumber - 1)
+			}
+			continue
+		}
+
+		code := false
+		instring := false
+		line := ctx.line
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inblock:
+				if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+					inblock = false
+					i++
+				}
+			case instring:
+				if c == '\\' {
+					i++
+				} else if c == '"' {
+					instring = false
+				}
+			case c == '#' || (c == '/' && i+1 < len(line) && line[i+1] == '/'):
+				ctx.commented(ctx.lineNumber - 1)
+				break scan
+			case c == '/' && i+1 < len(line) && line[i+1] == '*':
+				ctx.commented(ctx.lineNumber - 1)
+				inblock = true
+				startline = ctx.lineNumber
+				i++
+			case c == '"':
+				instring = true
+				code = true
+			case c == '<':
+				if m := heredocStart.FindSubmatch(line[i:]); m != nil {
+					heredoc = string(m[2])
+					i += len(m[0]) - 1
+				}
+				code = true
+			case !isspace(c):
+				code = true
+			}
+		}
+		if code {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+		}
+	}
+
+	if inblock {
+		ctx.unterminated(path, startline, "comment")
+	}
+
+	return sloc
+}
+
+// pascalCounter - Handle lanuages like Pascal, Modula 3, and OCaml
+func pascalCounter(ctx *countContext, path string, syntax pascalLike) uint64 {
+	var sloc uint64
+	var mode int = NORMAL /* NORMAL, INSTRING, or INCOMMENT */
+	var depth int         /* nesting depth of comments */
+	var startline uint
+
+	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
+		return 0
+	}
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == NORMAL {
+			if syntax.bracketcomments && c == '{' {
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				startline = ctx.lineNumber
+			} else if (c == '(') && ctx.ispeek('*') {
+				c, _ = ctx.getachar()
+				mode = INCOMMENT
+				ctx.commented(ctx.lineNumber)
+				depth = 1
+				startline = ctx.lineNumber
+			} else if syntax.nestcomments && c == '"' {
+				ctx.nonblank = true
+				mode = INSTRING
+				startline = ctx.lineNumber
+			} else if syntax.nestcomments && c == '\'' && (ctx.consume([]byte("\"'")) || ctx.consume([]byte("\\\"'"))) {
+				// A double-quote character literal, not a string
+				ctx.nonblank = true
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			} else if c == '\n' {
+				if ctx.nonblank {
+					sloc++
+					ctx.countLine(ctx.lineNumber)
+				}
+				ctx.nonblank = false
+			}
+		} else if mode == INSTRING {
+			if c == '\\' {
+				c, _ = ctx.getachar()
+			} else if c == '"' {
+				mode = NORMAL
+			} else if c == '\n' {
+				if ctx.nonblank {
+					sloc++
+					ctx.countLine(ctx.lineNumber)
+				}
+				ctx.nonblank = false
+			} else if !isspace(c) {
+				ctx.nonblank = true
+			}
+		} else { /* INCOMMENT mode */
+			if syntax.bracketcomments && c == '}' {
+				mode = NORMAL
+			} else if syntax.nestcomments && (c == '(') && ctx.ispeek('*') {
+				_, _ = ctx.getachar()
+				depth++
+			} else if syntax.nestcomments && c == '"' {
+				// Strings are lexed inside OCaml comments,
+				// so a *) in one doesn't end the comment.
+				for {
+					c, err = ctx.getachar()
+					if c == '\\' {
+						_, _ = ctx.getachar()
+					} else if c == '"' || err == io.EOF {
+						break
+					}
+				}
+			} else if (c == '*') && ctx.ispeek(')') {
+				_, _ = ctx.getachar()
+				depth--
+				if depth <= 0 {
+					mode = NORMAL
+				}
+			}
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		sloc++
+		ctx.countLine(ctx.lineNumber)
+	}
+	ctx.nonblank = false
+
+	if mode == INCOMMENT {
+		ctx.unterminated(path, startline, "comment")
+	} else if mode == INSTRING {
+		ctx.unterminated(path, startline, "string")
+	}
+
+	return sloc
+}
+
+// fortranStatements - split a Fortran line into ;-separated statements,
+// ignoring any trailing ! comment and anything inside quotes.
+func fortranStatements(line []byte) [][]byte {
+	var statements [][]byte
+	var quote byte
+	start := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+		} else if c == '!' {
+			line = line[:i]
+			break
+		} else if c == ';' {
+			statements = append(statements, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(statements, line[start:])
+}
+
+// fortranCounter - count SLOC in Fortran
+//
+// By default this counts physical lines that aren't comments.  With
+// fortranLogical set it counts statements instead: continuation lines
+// (a mark in column 6 of fixed form, or a trailing & in free form)
+// fold into the statement they continue, and each ;-separated
+// statement on a line counts separately.
+func fortranCounter(ctx *countContext, path string, syntax fortranLike) uint64 {
+	var sloc uint64
+	var continued bool // Does a free-form statement continue onto this line?
+
+	ctx.setup(path)
+	defer ctx.teardown()
+	ctx.skipHashbang()
+
+	for ctx.munchline() {
+		if syntax.comment.Match(ctx.line) && !syntax.nocomment.Match(ctx.line) {
+			continue
+		}
+		if !fortranLogical || syntax.nocomment.Match(ctx.line) {
+			sloc++
+			ctx.countLine(ctx.lineNumber - 1)
+			continue
+		}
+		line := bytes.TrimRight(ctx.line, " \t\r\n")
+		continuation := continued
+		if syntax.fixedform && len(line) > 5 && !bytes.ContainsRune(line[:5], '\t') && line[5] != ' ' && line[5] != '0' {
+			continuation = true
+		}
+		if syntax.fixedform && len(line) > 5 {
+			line = line[6:]
+		}
+		statements := fortranStatements(line)
+		last := bytes.TrimSpace(statements[len(statements)-1])
+		continued = !syntax.fixedform && bytes.HasSuffix(last, []byte("&"))
+		for i, statement := range statements {
+			if continuation && i == 0 {
+				continue // belongs to the statement being continued
+			}
+			if len(bytes.Trim(statement, " \t&")) > 0 {
+				sloc++
+				ctx.countLine(ctx.lineNumber - 1)
+			}
+		}
+	}
+	return sloc
+}
+
+// candidate - one way a file might be classified, as offered by dispatch
+type candidate struct {
+	name       string
+	verifier   func(*countContext, string) bool // must accept the file, if set
+	eolcomment string                           // for the generated-code filter
+	exempt     bool                             // not checked for generated code
+	fallible   bool                             // no code found means try the next one
+	count      func(ctx *countContext, path string, stat *SourceStat)
+}
+
+// dispatch - offer the ways a file might be classified, by extension,
+// basename, and hashbang, to try in order until it accepts one.
+// Verifiers are left for try to run, so that Generic, Classify, and
+// the ambiguity checker all go by the same list.
+func dispatch(ctx *countContext, path string, try func(candidate) bool) {
+	tables := ctx.rules()
+
+	for i := range tables.Generic {
+		lang := tables.Generic[i]
+		if strings.HasSuffix(path, lang.suffix) {
+			if try(candidate{name: lang.name, verifier: lang.verifier, eolcomment: lang.eolcomment, fallible: true,
+				count: func(ctx *countContext, path string, stat *SourceStat) {
+					syntax := lang
+					syntax.verifier = nil // already run by try
+					if len(syntax.commentleader) > 0 {
+						stat.SLOC = cFamilyCounter(ctx, path, syntax)
+						stat.Preproc = ctx.preproc
+						stat.Effective = ctx.effective
+						stat.API = ctx.api
+					} else {
+						stat.SLOC = genericCounter(ctx, path, syntax.eolcomment, nil)
+					}
+					if stat.SLOC > 0 && syntax.name == "c-header" && syntax.suffix == ".h" {
+						stat.objc = reallyObjCHeader(ctx, path)
+					}
+					if stat.SLOC > 0 && syntax.name == "c-header" && !stat.objc {
+						stat.cplusplus = syntax.suffix != ".h" || reallyCPlusPlus(ctx, path)
+					}
+				}}) {
+				return
+			}
+		}
+	}
+
+	if strings.HasSuffix(path, ".py") || hashbang(ctx, path, "python") {
+		if try(candidate{name: "python", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = pythonCounter(ctx, path)
+				stat.API = ctx.api
+			}}) {
+			return
+		}
+	}
+
+	// Ahead of Perl, whose hashbang test would claim perl6 scripts
+	if isRaku(ctx, path) {
+		if try(candidate{name: "raku", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = rakuCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".pl") || strings.HasSuffix(path, ".pm") || strings.HasSuffix(path, ".ph") || hashbang(ctx, path, "perl") {
+		if try(candidate{name: "perl", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC, stat.Data = perlCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".rb") || hashbang(ctx, path, "ruby") {
+		if try(candidate{name: "ruby", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = rubyCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if filepath.Base(path) == "wscript" {
+		if try(candidate{name: "waf", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = pythonCounter(ctx, path)
+				stat.API = ctx.api
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".gd") {
+		if try(candidate{name: "gdscript", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = pythonCounter(ctx, path)
+				stat.API = ctx.api
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".coffee") || hashbang(ctx, path, "coffee") {
+		if try(candidate{name: "coffeescript", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = coffeeCounter(ctx, path, "###", "###")
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".ls") || hashbang(ctx, path, "lsc") {
+		if try(candidate{name: "livescript", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = coffeeCounter(ctx, path, "/*", "*/")
+			}}) {
+			return
+		}
+	}
+
+	if isFSharp(path) {
+		if try(candidate{name: "f#", eolcomment: "//",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = fsharpCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".jl") || hashbang(ctx, path, "julia") {
+		if try(candidate{name: "julia", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = juliaCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if isAda(path) {
+		if try(candidate{name: "ada", eolcomment: "--",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = adaCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".abap") {
+		if try(candidate{name: "abap", eolcomment: "\"",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = abapCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".sas") {
+		if try(candidate{name: "sas", eolcomment: "*",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC, stat.Data = sasCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".d") {
+		if try(candidate{name: "d", verifier: reallyD, eolcomment: "//",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = dCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".st") {
+		if try(candidate{name: "smalltalk", eolcomment: "\"",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = smalltalkCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".jsx") {
+		if try(candidate{name: "jsx", eolcomment: "//",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = jsxCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".feature") {
+		if try(candidate{name: "gherkin", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = gherkinCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, ".ini") || strings.HasSuffix(path, ".cfg") || filepath.Base(path) == ".editorconfig" {
+		if try(candidate{name: "ini", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = iniCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	// A component is whatever language its scripts are written in.
+	if isComponent(path) {
+		lang, _ := extractComponent(ctx, path)
+		if try(candidate{name: lang, eolcomment: "//",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.Language, stat.SLOC = componentCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if isHCL(path) {
+		if try(candidate{name: "hcl", eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = hclCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	// Other .conf files have to look like server configuration.
+	if isNginxName(path) || strings.HasSuffix(path, ".conf") {
+		verifier := reallyNginx
+		if isNginxName(path) {
+			verifier = nil
+		}
+		if try(candidate{name: "nginx", verifier: verifier, eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = directiveCounter(ctx, path, true)
+			}}) {
+			return
+		}
+	}
+
+	if isApacheName(path) || strings.HasSuffix(path, ".conf") {
+		verifier := reallyApache
+		if isApacheName(path) {
+			verifier = nil
+		}
+		if try(candidate{name: "apache", verifier: verifier, eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = directiveCounter(ctx, path, false)
+			}}) {
+			return
+		}
+	}
+
+	if countXML && isXML(path) {
+		if try(candidate{name: "xml", exempt: true,
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = xmlCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if countTeX && isTeX(path) {
+		if try(candidate{name: "latex", exempt: true,
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = texCounter(ctx, path)
+			}}) {
+			return
+		}
+	}
+
+	if lang, ok := shellDotfiles[filepath.Base(path)]; ok {
+		if try(candidate{name: lang, eolcomment: "#",
+			count: func(ctx *countContext, path string, stat *SourceStat) {
+				stat.SLOC = genericCounter(ctx, path, "#", nil)
+			}}) {
+			return
+		}
+	}
+
+	for i := range tables.Scripting {
+		lang := tables.Scripting[i]
+		if strings.HasSuffix(path, lang.suffix) || hashbang(ctx, path, lang.hashbang) {
+			if try(candidate{name: lang.name, eolcomment: "#",
+				count: func(ctx *countContext, path string, stat *SourceStat) {
+					stat.SLOC = genericCounter(ctx, path, "#", nil)
+				}}) {
+				return
+			}
+		}
+	}
+
+	for i := range tables.PascalLikes {
+		lang := tables.PascalLikes[i]
+		if strings.HasSuffix(path, lang.suffix) {
+			if try(candidate{name: lang.name, verifier: lang.verifier, exempt: true, fallible: true,
+				count: func(ctx *countContext, path string, stat *SourceStat) {
+					syntax := lang
+					syntax.verifier = nil // already run by try
+					stat.SLOC = pascalCounter(ctx, path, syntax)
+				}}) {
+				return
+			}
+		}
+	}
+
+	for i := range tables.FortranLikes {
+		lang := tables.FortranLikes[i]
+		if strings.HasSuffix(path, lang.suffix) {
+			if try(candidate{name: lang.name, exempt: true, fallible: true,
+				count: func(ctx *countContext, path string, stat *SourceStat) {
+					stat.SLOC = fortranCounter(ctx, path, lang)
+				}}) {
+				return
+			}
+		}
+	}
+}
+
+// autofilter - should a candidate be turned away as generated code?
+func autofilter(ctx *countContext, path string, c candidate) bool {
+	if c.exempt {
+		return false
+	}
+	if wasGeneratedAutomatically(ctx, path, c.eolcomment) {
+		if debug > 0 {
+			fmt.Printf("automatic generation filter failed: %s\n", path)
+		}
+		return true
+	}
+	if debug > 0 {
+		fmt.Printf("automatic generation filter passed: %s\n", path)
+	}
+	return false
+}
+
+// Generic - recognize lots of languages with generic syntax
+func Generic(ctx *countContext, path string) SourceStat {
+	var stat SourceStat
+
+	dispatch(ctx, path, func(c candidate) bool {
+		if autofilter(ctx, path, c) {
+			stat.autogenerated = true
+			return true
+		}
+		if c.verifier != nil && !c.verifier(ctx, path) {
+			return false
+		}
+		attempt := SourceStat{Language: c.name}
+		c.count(ctx, path, &attempt)
+		if attempt.SLOC == 0 && c.fallible {
+			return false
+		}
+		if c.verifier != nil {
+			attempt.Confidence = ctx.confidence
+		}
+		stat = attempt
+		return true
+	})
+	return stat
+}
+
+// Classify - return the language of a file without counting its lines.
+// This goes by the same dispatch as Generic, running verifiers only
+// for extensions that need them.  An empty language name means the
+// file could not be classified, or that Generic would turn it away
+// as generated code.
+func Classify(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	lang, _ := classify(new(countContext), path)
+	return lang, nil
+}
+
+// classify - Classify under a context's tables, reading the file as
+// the context does, gzipped or not.  Also says whether the file was
+// turned away as generated code.
+func classify(ctx *countContext, path string) (string, bool) {
+	var lang string
+	var autogenerated bool
+	dispatch(ctx, path, func(c candidate) bool {
+		if autofilter(ctx, path, c) {
+			autogenerated = true
+			return true
+		}
+		if c.verifier != nil && !c.verifier(ctx, path) {
+			return false
+		}
+		lang = c.name
+		return true
+	})
+	return lang, autogenerated
+}
+
+// isRaku - is this Raku, by extension or by hashbang?
+func isRaku(ctx *countContext, path string) bool {
+	for _, ext := range []string{".raku", ".rakumod", ".rakutest", ".p6", ".pl6", ".pm6"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return hashbang(ctx, path, "raku") || hashbang(ctx, path, "perl6")
+}
+
+// isFSharp - is this F# source, signature, or script?
+func isFSharp(path string) bool {
+	return strings.HasSuffix(path, ".fs") || strings.HasSuffix(path, ".fsi") || strings.HasSuffix(path, ".fsx")
+}
+
+// Naming conventions of schema migration tools: Flyway versioned,
+// undo, and repeatable scripts, Django, and Rails.
+var defaultMigrationPatterns = []string{
+	"V*__*.sql", "U*__*.sql", "R__*.sql",
+	"migrations/[0-9][0-9][0-9][0-9]_*.py",
+	"migrate/[0-9]*_*.rb",
+}
+
+// Naming conventions of code generators: protoc and its gRPC plugins
+// for several languages, Visual Studio designers and source
+// generators, Kubernetes deepcopy-gen, and Dart's build_runner.
+var defaultGeneratedPatterns = []string{
+	"*.pb.go", "*.pb.gw.go", "*_pb2.py", "*_pb2_grpc.py", "*_pb2.pyi",
+	"*.pb.cc", "*.pb.h", "*_pb.js", "*_pb.d.ts", "*.pb.swift",
+	"*.generated.cs", "*.g.cs", "*.Designer.cs", "*.designer.cs",
+	"zz_generated.*.go", "*.g.dart", "*.freezed.dart",
+}
+
+// isMigration - is this named like a schema migration?
+func isMigration(path string) bool {
+	return matchesTail(path, migrationPatterns)
+}
+
+// isGeneratedName - is this named like the output of a code generator?
+func isGeneratedName(path string) bool {
+	return matchesTail(path, generatedPatterns)
+}
+
+// matchesTail - does a path match any of the patterns?  A pattern is a
+// glob matched against as many trailing path components as it has.
+func matchesTail(path string, patterns []string) bool {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for _, pattern := range patterns {
+		n := strings.Count(pattern, "/") + 1
+		if n > len(parts) {
+			continue
+		}
+		tail := strings.Join(parts[len(parts)-n:], "/")
+		if ok, _ := filepath.Match(pattern, tail); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Directories and file names that conventionally hold tests
+var testDirectories = map[string]bool{
+	"test": true, "tests": true, "testing": true, "testdata": true,
+	"__tests__": true, "spec": true, "t": true,
+}
+var testNamePatterns = []string{
+	"*_test.*", "test_*.py", "*_spec.rb", "*.test.*", "*.spec.*",
+	"*Test.java", "*Tests.java", "*Tests.cs",
+}
+
+// isTestPath - does a path under the root look like part of a test suite?
+func isTestPath(path string) bool {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if testDirectories[dir] {
+			return true
+		}
+	}
+	base := parts[len(parts)-1]
+	for _, pattern := range testNamePatterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Module paths declared by go.mod files, by directory, for -bymodule
+var goModules = map[string]string{}
+var goModulesLock sync.Mutex
+
+// goModule - the module path a go.mod in dir declares, or "" if
+// there is none
+func goModule(dir string) string {
+	goModulesLock.Lock()
+	defer goModulesLock.Unlock()
+	name, ok := goModules[dir]
+	if !ok {
+		if text, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			if m := goModuleDecl.FindSubmatch(text); m != nil {
+				name = string(m[1])
+			} else {
+				name = dir
+			}
+		}
+		goModules[dir] = name
+	}
+	return name
+}
+
+// javaPackage - the package a Java file declares, or "" for the
+// default package
+func javaPackage(ctx *countContext, path string) string {
+	if !ctx.setup(path) {
+		return ""
+	}
+	defer ctx.teardown()
+	for ctx.munchline() {
+		if m := javaPackageDecl.FindSubmatch(ctx.line); m != nil {
+			return string(m[1])
+		}
+	}
+	return ""
+}
+
+// moduleOf - the module or package a file under the root belongs to:
+// for Go, the one declared by the nearest go.mod; for Python, the
+// outermost of the directories with an __init__.py that enclose it;
+// for Java, the package it declares.  Anything else, or a file
+// none of those claim, belongs to the top directory it is under, or
+// to "." at the top.
+func moduleOf(ctx *countContext, path string, lang string) string {
+	dir := filepath.Dir(path)
+	switch lang {
+	case "go":
+		for d := dir; ; d = filepath.Dir(d) {
+			if name := goModule(d); name != "" {
+				return name
+			}
+			if d == "." || d == string(filepath.Separator) {
+				break
 			}
-			if stat.SLOC > 0 {
-				stat.Language = lang.name
-				return stat
+		}
+	case "python":
+		var pkg string
+		for d := dir; d != "." && d != string(filepath.Separator); d = filepath.Dir(d) {
+			if _, err := os.Stat(filepath.Join(d, "__init__.py")); err != nil {
+				break
 			}
+			pkg = d
+		}
+		if pkg != "" {
+			return filepath.ToSlash(pkg)
+		}
+	case "java":
+		if pkg := javaPackage(ctx, path); pkg != "" {
+			return pkg
 		}
 	}
+	return strings.SplitN(filepath.ToSlash(dir), "/", 2)[0]
+}
 
-	if strings.HasSuffix(path, ".py") || hashbang(ctx, path, "python") {
-		if autofilter("#") {
-			return stat
+// findEmbedded - the absolute paths of the files that //go:embed
+// directives in Go sources under the roots pull in.  Patterns are
+// globs relative to the directory of the Go file, and a directory
+// brings in everything beneath it.
+func findEmbedded(roots []string) map[string]bool {
+	found := map[string]bool{}
+	add := func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil {
+				found[abs] = true
+			}
 		}
-		stat.Language = "python"
-		stat.SLOC = pythonCounter(ctx, path)
-		return stat
+		return nil
+	}
+	for _, root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+				return nil
+			}
+			ctx := new(countContext)
+			ctx.setup(path)
+			defer ctx.teardown()
+			for ctx.munchline() {
+				line := bytes.TrimSpace(ctx.line)
+				if !bytes.HasPrefix(line, []byte("//go:embed ")) {
+					continue
+				}
+				for _, pattern := range strings.Fields(string(line[len("//go:embed "):])) {
+					pattern = strings.Trim(pattern, "\"`")
+					pattern = strings.TrimPrefix(pattern, "all:")
+					matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), pattern))
+					for _, match := range matches {
+						filepath.Walk(match, add)
+					}
+				}
+			}
+			return nil
+		})
 	}
+	return found
+}
 
-	if strings.HasSuffix(path, ".pl") || strings.HasSuffix(path, ".pm") || strings.HasSuffix(path, ".ph") || hashbang(ctx, path, "perl") {
-		if autofilter("#") {
-			return stat
+// fetchTarball - download a tarball, gzipped or not, and unpack it
+// into a temporary directory, returning the directory to count and
+// the one to remove afterwards.  The archive is streamed to disk a
+// file at a time, so memory stays bounded however large it is, and
+// unpacking stops with an error past limit bytes, so disk use is
+// bounded too.  Only http and https are fetched, and redirects are
+// followed only to those, so a server can't point loccount at a local
+// file; the whole download must finish within the timeout.  Links,
+// devices, and entries that would land outside the directory are
+// skipped.  A single directory wrapping everything, as GitHub puts in
+// its archives, is counted as the top.
+func fetchTarball(url string, timeout time.Duration, limit int64) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	webOnly := func(req *http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("won't fetch %s: only http and https URLs are allowed", req.URL)
+		}
+		return nil
+	}
+	if err := webOnly(req); err != nil {
+		return "", "", err
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return webOnly(req)
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("fetch failed: %s", resp.Status)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var archive io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return "", "", err
 		}
-		stat.Language = "perl"
-		stat.SLOC = perlCounter(ctx, path)
-		return stat
+		defer gz.Close()
+		archive = gz
 	}
 
-	if filepath.Base(path) == "wscript" {
-		if autofilter("#") {
-			return stat
+	dir, err := os.MkdirTemp("", "loccount")
+	if err != nil {
+		return "", "", err
+	}
+	tr := tar.NewReader(archive)
+	tooBig := fmt.Errorf("archive unpacks to more than %d bytes", limit)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			var f *os.File
+			if hdr.Size > limit {
+				err = tooBig
+				break
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				break
+			}
+			// Keep execute permission, which hashbang lookup wants.
+			f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0755|0600)
+			if err != nil {
+				break
+			}
+			var n int64
+			n, err = io.CopyN(f, tr, limit+1)
+			if err == io.EOF {
+				err = nil
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if limit -= n; err == nil && limit < 0 {
+				err = tooBig
+			}
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
 		}
-		stat.Language = "waf"
-		stat.SLOC = pythonCounter(ctx, path)
-		return stat
 	}
 
-	for i := range scriptingLanguages {
-		if autofilter("#") {
-			return stat
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), dir, nil
+	}
+	return dir, dir, nil
+}
+
+// isAda - is this Ada, or Ada for Oracle's preprocessor (.pad)?
+func isAda(path string) bool {
+	for _, ext := range []string{".ada", ".adb", ".ads", ".pad"} {
+		if strings.HasSuffix(path, ext) {
+			return true
 		}
-		lang := scriptingLanguages[i]
-		if strings.HasSuffix(path, lang.suffix) || hashbang(ctx, path, lang.hashbang) {
-			stat.Language = lang.name
-			stat.SLOC = genericCounter(ctx, path, "#", nil)
-			return stat
+	}
+	return false
+}
+
+// isComponent - is this a Svelte or Astro component?
+func isComponent(path string) bool {
+	return strings.HasSuffix(path, ".svelte") || strings.HasSuffix(path, ".astro")
+}
+
+// isHCL - is this Terraform or other HCL configuration?
+func isHCL(path string) bool {
+	return strings.HasSuffix(path, ".tf") || strings.HasSuffix(path, ".tf.json") || strings.HasSuffix(path, ".hcl")
+}
+
+// isXML - is this XML markup, including schema and service descriptions?
+func isXML(path string) bool {
+	return strings.HasSuffix(path, ".xml") || strings.HasSuffix(path, ".xsd") || strings.HasSuffix(path, ".wsdl")
+}
+
+// isTeX - is this TeX or LaTeX source, a package, or a document class?
+func isTeX(path string) bool {
+	return strings.HasSuffix(path, ".tex") || strings.HasSuffix(path, ".ltx") || strings.HasSuffix(path, ".sty") || strings.HasSuffix(path, ".cls")
+}
+
+// withoutDots - a path less its . and .. components, which name no
+// hidden file or directory
+func withoutDots(path string) string {
+	var kept []string
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem != "." && elem != ".." {
+			kept = append(kept, elem)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// isNginxName - is this named as Nginx configuration?  Other .conf
+// files have to look like it.
+func isNginxName(path string) bool {
+	return filepath.Base(path) == "nginx.conf"
+}
+
+// isApacheName - is this named as Apache httpd configuration?
+func isApacheName(path string) bool {
+	switch filepath.Base(path) {
+	case "httpd.conf", "apache.conf", "apache2.conf":
+		return true
+	}
+	return false
+}
+
+func isDirectory(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsDir()
+}
+
+func isRegular(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
+
+// dupDetector - notices files whose contents have already been seen.
+// Hashing every file would be slow, so a file is hashed only when
+// another file of the same size turns up.  Hard links are caught too,
+// because their contents are identical.
+type dupDetector struct {
+	lock        sync.Mutex
+	firstBySize map[int64]string // first unhashed path of each size
+	hashes      map[[sha256.Size]byte]bool
+	collapsed   uint
+}
+
+func newDupDetector() *dupDetector {
+	return &dupDetector{
+		firstBySize: make(map[int64]string),
+		hashes:      make(map[[sha256.Size]byte]bool),
+	}
+}
+
+// lineCount - count all lines in a file, code or not.  Unlike wc -l,
+// a final line without a terminating newline is counted, as the SLOC
+// counters do.
+func lineCount(ctx *countContext, path string) uint64 {
+	var lines uint64
+	var last byte = '\n'
+
+	if !ctx.setup(path) {
+		return 0
+	}
+	defer ctx.teardown()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := ctx.rc.Read(buf)
+		if n > 0 {
+			lines += uint64(bytes.Count(buf[:n], []byte{'\n'}))
+			last = buf[n-1]
+		}
+		if err != nil {
+			break
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// hasText - is there anything but whitespace in a file?  In one that
+// holds no code, that will be comments.
+func hasText(ctx *countContext, path string) bool {
+	if !ctx.setup(path) {
+		return false
+	}
+	defer ctx.teardown()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := ctx.rc.Read(buf)
+		if len(bytes.TrimSpace(buf[:n])) > 0 {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// charCount - count the bytes and UTF-8 characters in a file.  A byte
+// that can't continue a multibyte sequence starts a character, which
+// is how utf8 decodes valid text, and needs no state across reads.
+func charCount(ctx *countContext, path string) (uint64, uint64) {
+	var size, runes uint64
+
+	if !ctx.setup(path) {
+		return 0, 0
+	}
+	defer ctx.teardown()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := ctx.rc.Read(buf)
+		size += uint64(n)
+		for _, b := range buf[:n] {
+			if utf8.RuneStart(b) {
+				runes++
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return size, runes
+}
+
+// blameLines - attribute the code lines of a file to the authors who
+// last changed them, according to git blame.  Returns nil if the file
+// isn't under git.
+func blameLines(path string, codeLines map[uint]bool) map[string]uint64 {
+	out, err := exec.Command("git", "blame", "--line-porcelain", "--", path).Output()
+	if err != nil {
+		if debug > 0 {
+			log.Printf("git blame failed on %s: %v\n", path, err)
+		}
+		return nil
+	}
+	authors := make(map[string]uint64)
+	var line uint64
+	for _, text := range bytes.Split(out, []byte("\n")) {
+		if len(text) == 0 || text[0] == '\t' {
+			continue // content line
+		}
+		fields := bytes.Fields(text)
+		if len(fields) >= 3 && isHexID(fields[0]) {
+			// Header: commit, original line, final line
+			line, _ = strconv.ParseUint(string(fields[2]), 10, 0)
+		} else if bytes.HasPrefix(text, []byte("author ")) && codeLines[uint(line)] {
+			authors[string(text[len("author "):])]++
+		}
+	}
+	return authors
+}
+
+// isHexID - does this look like a SHA-1 or SHA-256 commit ID?
+func isHexID(id []byte) bool {
+	if len(id) != 40 && len(id) != 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ambiguityChecker - notices files whose extension is shared by several
+// languages and warns when the verifiers don't settle which one it is.
+// Languages without verifiers are fallbacks, of which the first is
+// taken when no verifier accepts the file, so together they count as
+// one; where none has a verifier the table order alone decides.
+type ambiguityChecker struct {
+	lock      sync.Mutex
+	bySuffix  map[string][]string // languages sharing each extension
+	ambiguous []string
+}
+
+func newAmbiguityChecker() *ambiguityChecker {
+	a := &ambiguityChecker{bySuffix: make(map[string][]string)}
+	for lang, suffixes := range languageExtensions() {
+		for _, suffix := range suffixes {
+			if strings.HasPrefix(suffix, ".") && !contains(a.bySuffix[suffix], lang) {
+				a.bySuffix[suffix] = append(a.bySuffix[suffix], lang)
+			}
+		}
+	}
+	for suffix, langs := range a.bySuffix {
+		if len(langs) < 2 {
+			delete(a.bySuffix, suffix)
+		} else {
+			sort.Strings(langs)
+		}
+	}
+	return a
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// check - warn if none, or more than one, of the languages sharing
+// path's extension claims it.  What each language must pass is what
+// dispatch would ask of it.
+func (a *ambiguityChecker) check(path string) {
+	for suffix, langs := range a.bySuffix {
+		if !strings.HasSuffix(path, suffix) {
+			continue
+		}
+		verifiers := make(map[string]func(*countContext, string) bool)
+		dispatch(new(countContext), path, func(c candidate) bool {
+			if _, ok := verifiers[c.name]; !ok {
+				verifiers[c.name] = c.verifier
+			}
+			return false
+		})
+		var offered, claimants []string
+		var fallback, verified bool
+		for _, lang := range langs {
+			verifier, ok := verifiers[lang]
+			if !ok {
+				continue
+			}
+			offered = append(offered, lang)
+			if verifier == nil {
+				fallback = true
+				continue
+			}
+			verified = true
+			if verifier(new(countContext), path) {
+				claimants = append(claimants, lang)
+			}
+		}
+		if len(offered) < 2 || !verified {
+			// Nothing for a verifier to settle, as with a component,
+			// with one of the languages not being counted, or with
+			// PHP and its versioned variants.
+			return
+		}
+		if len(claimants) > 1 || (len(claimants) == 0 && !fallback) {
+			log.Printf("%q: ambiguous, claimed by %v of %v\n",
+				path, claimants, langs)
+			a.lock.Lock()
+			a.ambiguous = append(a.ambiguous, path)
+			a.lock.Unlock()
+		}
+		return
+	}
+}
+
+// report - summarize the ambiguous files on stderr
+func (a *ambiguityChecker) report() {
+	sort.Strings(a.ambiguous)
+	fmt.Fprintf(os.Stderr, "%d files with ambiguous classification\n",
+		len(a.ambiguous))
+	for _, path := range a.ambiguous {
+		fmt.Fprintf(os.Stderr, "    %s\n", path)
+	}
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// duplicate - has a file with the same contents been seen before?
+func (d *dupDetector) duplicate(path string, size int64) bool {
+	// Empty files are all alike, but contribute nothing anyway.
+	if size == 0 {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	first, seen := d.firstBySize[size]
+	if !seen {
+		// Paths are relative to the current root, which will
+		// change, so remember this one absolutely.
+		d.firstBySize[size], _ = filepath.Abs(path)
+		return false
+	}
+	if first != "" {
+		if sum, err := hashFile(first); err == nil {
+			d.hashes[sum] = true
 		}
+		d.firstBySize[size] = ""
 	}
+	sum, err := hashFile(path)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if d.hashes[sum] {
+		d.collapsed++
+		return true
+	}
+	d.hashes[sum] = true
+	return false
+}
+
+// filter - winnows out uninteresting paths before handing them to process
+func filter(path string, info os.FileInfo, err error) error {
+	return visit(nil, path, info, err, func(st SourceStat) {
+		pipeline <- st
+	})
+}
+
+// CountWith - count the tree under root using the given language tables,
+// returning a record for every file that passes the filters.  Paths are
+// reported as found under root.  Nothing here changes the working
+// directory or touches the report pipeline, so separate runs, with
+// different tables, may proceed at the same time.
+func CountWith(tables LanguageTables, root string) ([]SourceStat, error) {
+	var lock sync.Mutex
+	var stats []SourceStat
+	err := Walk(root, func(path string, info os.FileInfo, err error) error {
+		return visit(&tables, path, info, err, func(st SourceStat) {
+			lock.Lock()
+			stats = append(stats, st)
+			lock.Unlock()
+		})
+	})
+	return stats, err
+}
+
+// JSONStreamWriter - writes the results of counts as they arrive, as
+// newline-delimited JSON events, for a service whose frontend wants to
+// show progress.  Every event has a "type": "file" for a file with
+// code, "error" for a path that couldn't be walked or a file that
+// ended inside a comment or string, and "summary" when a tree is done.
+// Files are counted by several goroutines at once, so writes are
+// serialized, each event going out whole in a single write.
+type JSONStreamWriter struct {
+	Follow bool // walk symbolic links to directories
+	// Header folding in summaries, as for the report: where
+	// headers go, if somewhere in particular, and whether to
+	// leave them unmerged
+	HeadersInto    string
+	NoMergeHeaders bool
+	lock           sync.Mutex
+	w              io.Writer
+	err            error // the first write that failed
+}
+
+// Stream events, as encoding/json writes them
+type fileEvent struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Language  string `json:"language"`
+	LineCount uint64 `json:"linecount"`
+}
+
+type errorEvent struct {
+	Type  string `json:"type"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type languageTally struct {
+	Language  string `json:"language"`
+	LineCount uint64 `json:"linecount"`
+	FileCount uint64 `json:"filecount"`
+}
+
+type summaryEvent struct {
+	Type      string          `json:"type"`
+	Root      string          `json:"root"`
+	LineCount uint64          `json:"linecount"`
+	FileCount uint64          `json:"filecount"`
+	Languages []languageTally `json:"languages"`
+}
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			stat.Language = lang.name
-			stat.SLOC = pascalCounter(ctx, path, lang)
-			if stat.SLOC > 0 {
-				return stat
-			}
-		}
-	}
+// NewJSONStreamWriter - make a stream of events written to w
+func NewJSONStreamWriter(w io.Writer) *JSONStreamWriter {
+	return &JSONStreamWriter{w: w}
+}
 
-	for i := range fortranLikes {
-		lang := fortranLikes[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			stat.Language = lang.name
-			stat.SLOC = fortranCounter(ctx, path, lang)
-			if stat.SLOC > 0 {
-				return stat
-			}
-		}
+// emit - write one event as a line of its own.  After a write fails,
+// the rest are dropped.
+func (s *JSONStreamWriter) emit(event interface{}) {
+	line, err := json.Marshal(event)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err == nil {
+		s.err = err
 	}
+	if s.err == nil {
+		_, s.err = s.w.Write(append(line, '\n'))
+	}
+}
 
-	return stat
+// File - report one file's count
+func (s *JSONStreamWriter) File(st SourceStat) {
+	s.emit(fileEvent{"file", st.Path, st.Language, st.SLOC})
 }
 
-func isDirectory(path string) bool {
-	fileInfo, err := os.Stat(path)
-	return err == nil && fileInfo.Mode().IsDir()
+// Error - report trouble with a path
+func (s *JSONStreamWriter) Error(path string, err error) {
+	s.emit(errorEvent{"error", path, err.Error()})
 }
 
-func isRegular(path string) bool {
-	fileInfo, err := os.Stat(path)
-	return err == nil && fileInfo.Mode().IsRegular()
+// summary - report a tree's totals, with languages in descending
+// order of lines
+func (s *JSONStreamWriter) summary(root string, totals countRecord, counts map[string]countRecord) {
+	var byLines sortable
+	for _, r := range counts {
+		byLines = append(byLines, r)
+	}
+	// Ties go by name, so the same tree always streams the same.
+	sort.Slice(byLines, func(i, j int) bool {
+		return byLines[i].Language < byLines[j].Language
+	})
+	sort.Stable(byLines)
+	event := summaryEvent{"summary", root, totals.LineCount, totals.FileCount, []languageTally{}}
+	for _, r := range byLines {
+		event.Languages = append(event.Languages,
+			languageTally{r.Language, r.LineCount, r.FileCount})
+	}
+	s.emit(event)
 }
 
-// filter - winnows out uninteresting paths before handing them to process
-func filter(path string, info os.FileInfo, err error) error {
+// Count - count the tree under root using the given language tables,
+// streaming an event for each file as it is counted and a summary at
+// the end.  Paths are reported as found under root, as by CountWith.
+// Trouble with a path is reported and the walk goes on; the error
+// returned is the first from writing the stream.
+func (s *JSONStreamWriter) Count(tables LanguageTables, root string) error {
+	var lock sync.Mutex
+	var totals countRecord
+	counts := map[string]countRecord{}
+	WalkWith(context.Background(), root, WalkOptions{FollowSymlinks: s.Follow}, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			s.Error(path, err)
+			return nil
+		}
+		return visit(&tables, path, info, nil, func(st SourceStat) {
+			for _, problem := range st.problems {
+				s.Error(st.Path, errors.New(problem))
+			}
+			if st.SLOC == 0 {
+				return
+			}
+			s.File(st)
+			lock.Lock()
+			lang := summaryLanguage(st)
+			var tmp = counts[lang]
+			tmp.Language = lang
+			tmp.add(st, false)
+			counts[lang] = tmp
+			totals.add(st, false)
+			lock.Unlock()
+		})
+	})
+	foldHeaders(counts, tables.CHeaderPriority, s.HeadersInto, s.NoMergeHeaders)
+	s.summary(root, totals, counts)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// visit - filter one path and, if it is interesting, count it under
+// the given tables (nil for the defaults) and pass on the result
+func visit(tables *LanguageTables, path string, info os.FileInfo, err error, emit func(SourceStat)) error {
 	if debug > 0 {
 		fmt.Printf("entering filter: %s\n", path)
 	}
-	suffix := filepath.Ext(path)
-	if suffix != "" && neverInterestingBySuffix[suffix] {
+	// With -dry-run, files (not directories) turned away are
+	// passed on, so they can be listed with the filter to blame.
+	reject := func(filter string) {
 		if debug > 0 {
-			fmt.Printf("suffix filter failed: %s\n", path)
+			fmt.Printf("%s filter failed: %s\n", filter, path)
 		}
+		if dryRun && (info == nil || !info.IsDir()) {
+			emit(SourceStat{Path: path, rejected: filter})
+		}
+	}
+	suffix := filepath.Ext(path)
+	if suffix != "" && neverInterestingBySuffix[suffix] {
+		reject("suffix")
 		return err
 	}
+	for i := range neverInterestingByCompoundSuffix {
+		if strings.HasSuffix(path, neverInterestingByCompoundSuffix[i]) {
+			reject("compound suffix")
+			return err
+		}
+	}
+	// Shell startup files are worth counting, leading dot or no.
+	dotfile := shellDotfiles[filepath.Base(path)] != "" && (info == nil || !info.IsDir())
+	// A root given as ../src is no hidden directory.
+	judged := withoutDots(path)
 	for i := range neverInterestingByPrefix {
-		if strings.HasPrefix(path, neverInterestingByPrefix[i]) {
-			if debug > 0 {
-				fmt.Printf("prefix filter failed: %s\n", path)
-			}
+		if !dotfile && strings.HasPrefix(judged, neverInterestingByPrefix[i]) {
+			reject("prefix")
 			return err
 		}
 	}
 	for i := range neverInterestingByInfix {
-		if strings.Contains(path, neverInterestingByInfix[i]) {
-			if debug > 0 {
-				fmt.Printf("infix filter failed: %s\n", path)
-			}
+		if !dotfile && strings.Contains(judged, neverInterestingByInfix[i]) {
+			reject("infix")
 			if isDirectory(path) {
 				if debug > 0 {
 					fmt.Printf("directory skipped: %s\n", path)
@@ -1480,79 +5464,425 @@ func filter(path string, info os.FileInfo, err error) error {
 		}
 	}
 	basename := filepath.Base(path)
-	if neverInterestingByBasename[strings.ToLower(basename)] {
-		if debug > 0 {
-			fmt.Printf("basename filter failed: %s\n", path)
+	if skipTestdata && basename == "testdata" && info != nil && info.IsDir() {
+		// As the go tool does
+		reject("testdata")
+		return filepath.SkipDir
+	}
+	if embedded != nil {
+		if abs, aerr := filepath.Abs(path); aerr == nil && embedded[abs] {
+			reject("go:embed")
+			return err
 		}
+	}
+	if neverInterestingByBasename[strings.ToLower(basename)] {
+		reject("basename")
 		return err
 	}
 	for i := range exclusions {
 		if path == exclusions[i] || strings.HasPrefix(path, exclusions[i]+"/") {
-			if debug > 0 {
-				fmt.Printf("exclusion '%s' filter failed: %s\n", exclusions, path)
-			}
+			reject(fmt.Sprintf("exclusion '%s'", exclusions[i]))
 			return err
 		}
 	}
 
 	/* has to come after the infix check for directory */
 	if !isRegular(path) {
-		if debug > 0 {
-			fmt.Printf("regular-file filter failed: %s\n", path)
-		}
+		reject("regular-file")
 		return err
 	}
 
+	if extOnly != nil {
+		allowed := false
+		name := path
+		if gunzip {
+			name = strings.TrimSuffix(path, ".gz")
+		}
+		for i := range extOnly {
+			if strings.HasSuffix(name, extOnly[i]) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			reject("extension allowlist")
+			return err
+		}
+	}
+
 	/* toss generated Makefiles */
 	if basename == "Makefile" {
 		if _, err := os.Stat(path + ".in"); err == nil {
-			if debug > 0 {
-				fmt.Printf("generated-makefile filter failed: %s\n", path)
-			}
+			reject("generated-makefile")
 			return err
 		}
 	}
 
+	if duplicates != nil && info != nil && duplicates.duplicate(path, info.Size()) {
+		reject("duplicate")
+		return err
+	}
+
+	// Patterns may name the directory, which the root may be.
+	generatedName := false
+	if abs, aerr := filepath.Abs(path); aerr == nil && generatedPatterns != nil {
+		generatedName = isGeneratedName(abs)
+	}
+	if dryRun && generatedName && !countGenerated {
+		// Otherwise these are counted, to be tallied apart.
+		reject("generated-name")
+		return err
+	}
+
 	if debug > 0 {
 		fmt.Printf("passed filter: %s\n", path)
 	}
 
+	if ambiguity != nil {
+		ambiguity.check(path)
+	}
+
 	// Now the real work gets done
-	ctx := new(countContext)
-	st := Generic(ctx, path)
+	ctx := &countContext{tables: tables}
+	if blaming || indenting || commentDensity {
+		ctx.codeLines = make(map[uint]bool)
+	}
+	if indenting {
+		ctx.leading = make(map[uint]string)
+	}
+	if commentDensity {
+		ctx.commentLines = make(map[uint]bool)
+	}
+	if findSQL {
+		ctx.sqlLines = make(map[uint]bool)
+	}
+	// A compressed file is classified and counted under its inner name.
+	countPath := path
+	if gunzip && strings.HasSuffix(path, ".gz") {
+		ctx.gzipped = true
+		countPath = strings.TrimSuffix(path, ".gz")
+	}
+	if dryRun {
+		lang, autogenerated := classify(ctx, countPath)
+		if autogenerated {
+			reject("generated")
+			return err
+		}
+		emit(SourceStat{Path: path, Language: lang, Classified: lang != ""})
+		return err
+	}
+	st := Generic(ctx, countPath)
 	st.Path = path
-	pipeline <- st
+	st.Classified = (st.Language != "")
+	if !st.Classified && (countEmpty || commentOnly) && !st.autogenerated {
+		// Counters that find no code don't name the language.
+		if lang, _ := classify(&countContext{tables: tables, gzipped: ctx.gzipped}, countPath); lang != "" {
+			st.Language = lang
+			st.Classified = true
+		}
+	}
+	// A generated file's header is no comment-only file's comments.
+	if commentOnly && st.Classified && st.SLOC == 0 && !st.autogenerated {
+		st.comments = hasText(ctx, countPath)
+	}
+	if allLines && st.SLOC > 0 {
+		st.TotalLines = lineCount(ctx, countPath)
+	}
+	if countChars && st.SLOC > 0 {
+		st.Bytes, st.Runes = charCount(ctx, countPath)
+	}
+	st.EmbeddedSQL = uint64(len(ctx.sqlLines))
+	st.problems = ctx.problems
+	if countEffective && !ctx.logical {
+		// Counters that don't know statements report physical lines.
+		st.Effective = st.SLOC
+	}
+	if blaming && st.SLOC > 0 && !ctx.gzipped {
+		st.blame = blameLines(path, ctx.codeLines)
+	}
+	if indenting {
+		tab := tabWidth(st.Language)
+		for line := range ctx.codeLines {
+			st.Indent += indentWidth(ctx.leading[line], tab)
+		}
+	}
+	for line := range ctx.commentLines {
+		if ctx.codeLines[line] {
+			st.Commented++
+		}
+	}
+	if byModule && st.SLOC > 0 {
+		st.module = moduleOf(ctx, countPath, st.Language)
+	}
+	st.generated = generatedName
+	if migrationPatterns != nil {
+		// Patterns may name the directory, which the root may be.
+		if abs, err := filepath.Abs(path); err == nil {
+			st.migration = isMigration(abs)
+		}
+	}
+	if splitTests {
+		st.test = isTestPath(path)
+	}
+	if relativeBase != "" {
+		// We're chdired into the root, so Abs recovers the true path.
+		if abs, err := filepath.Abs(path); err == nil {
+			if rel, err := filepath.Rel(relativeBase, abs); err == nil {
+				st.Path = rel
+			}
+		}
+	}
+	emit(st)
 
 	return err
 }
 
+// countRecord - per-language totals; fields are exported for -format
 type countRecord struct {
-	language  string
-	linecount uint
-	filecount uint
+	Language     string
+	LineCount    uint64
+	FileCount    uint64
+	DataCount    uint64
+	PreprocCount uint64
+	TotalLines   uint64
+	Effective    uint64
+	API          uint64 // declaration lines, for -api
+	Indent       uint64 // leading columns summed over code lines, for -indent
+	Commented    uint64 // code lines that also hold a comment, for -comment-density
+	Bytes        uint64
+	Runes        uint64
+	files        []SourceStat // collected only for -detailed and -lang-stats
+}
+
+// add - tally one file's statistics into a record
+func (r *countRecord) add(st SourceStat, keepFiles bool) {
+	r.LineCount += st.SLOC
+	r.DataCount += st.Data
+	r.PreprocCount += st.Preproc
+	r.TotalLines += st.TotalLines
+	r.Effective += st.Effective
+	r.API += st.API
+	r.Indent += st.Indent
+	r.Commented += st.Commented
+	r.Bytes += st.Bytes
+	r.Runes += st.Runes
+	r.FileCount++
+	if keepFiles {
+		r.files = append(r.files, st)
+	}
+}
+
+// mergeLines - fold another record's line tallies into this one
+func (r *countRecord) mergeLines(other countRecord) {
+	r.LineCount += other.LineCount
+	r.DataCount += other.DataCount
+	r.PreprocCount += other.PreprocCount
+	r.TotalLines += other.TotalLines
+	r.Effective += other.Effective
+	r.API += other.API
+	r.Indent += other.Indent
+	r.Commented += other.Commented
+	r.Bytes += other.Bytes
+	r.Runes += other.Runes
+	r.files = append(r.files, other.files...)
+}
+
+// fileSizes - the mean, median, least, and greatest line count of
+// some files
+func fileSizes(files []SourceStat) (float64, float64, uint64, uint64) {
+	if len(files) == 0 {
+		return 0, 0, 0, 0
+	}
+	sizes := make([]uint64, len(files))
+	var total uint64
+	for i, f := range files {
+		sizes[i] = f.SLOC
+		total += f.SLOC
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	n := len(sizes)
+	median := float64(sizes[n/2])
+	if n%2 == 0 {
+		median = float64(sizes[n/2-1]+sizes[n/2]) / 2
+	}
+	return float64(total) / float64(n), median, sizes[0], sizes[n-1]
+}
+
+// formatContext - what a -format template sees for each summary line
+type formatContext struct {
+	countRecord
+	Percent float64
+	Totals  countRecord
+}
+
+// reportDryRun - list what the filters made of each file, and how
+// many got through
+func reportDryRun(stats []SourceStat) {
+	var passed, unknown, rejected int
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+	for _, st := range stats {
+		if st.rejected != "" {
+			fmt.Fprintf(stdout, "%s rejected by %s filter\n", st.Path, st.rejected)
+			rejected++
+		} else if st.Classified {
+			fmt.Fprintf(stdout, "%s passed as %s\n", st.Path, st.Language)
+			passed++
+		} else {
+			fmt.Fprintf(stdout, "%s passed unclassified\n", st.Path)
+			passed++
+			unknown++
+		}
+	}
+	fmt.Fprintf(stdout, "%d passed (%d unclassified), %d rejected\n",
+		passed, unknown, rejected)
+}
+
+// corpusHash - digest which files were counted, as what, and how many
+// lines each had.  The records are sorted by path and hashed as lines
+// of path, language, and SLOC separated by tabs, so the digest doesn't
+// depend on the order the walk delivered them in.
+func corpusHash(files []SourceStat) [sha256.Size]byte {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	h := sha256.New()
+	for _, st := range files {
+		fmt.Fprintf(h, "%s\t%s\t%d\n", st.Path, st.Language, st.SLOC)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// reportBlame - summarize code lines by the author who last changed them
+func reportBlame(authors map[string]uint64, asJSON bool) {
+	var names []string
+	var total uint64
+	for name, n := range authors {
+		names = append(names, name)
+		total += n
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if authors[names[i]] != authors[names[j]] {
+			return authors[names[i]] > authors[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if asJSON {
+			fmt.Fprintf(stdout, "{\"author\":%s, \"linecount\":%d}\n",
+				jsonString(name), authors[name])
+		} else {
+			fmt.Fprintf(stdout, "%-24s %7d (%2.2f%%)\n", name, authors[name],
+				float64(authors[name])*100.0/float64(total))
+		}
+	}
+}
+
+// moduleKey - a language's share of a module, for -bymodule
+type moduleKey struct {
+	module, language string
+}
+
+// reportModules - summarize code lines by module and language
+func reportModules(modules map[moduleKey]countRecord, asJSON bool) {
+	var keys []moduleKey
+	var total uint64
+	for key, r := range modules {
+		keys = append(keys, key)
+		total += r.LineCount
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := modules[keys[i]], modules[keys[j]]
+		if a.LineCount != b.LineCount {
+			return a.LineCount > b.LineCount
+		}
+		if keys[i].module != keys[j].module {
+			return keys[i].module < keys[j].module
+		}
+		return keys[i].language < keys[j].language
+	})
+	for _, key := range keys {
+		r := modules[key]
+		if asJSON {
+			fmt.Fprintf(stdout, "{\"module\":%s, \"language\":%s, \"linecount\":%d, \"filecount\":%d}\n",
+				jsonString(key.module), jsonString(key.language), r.LineCount, r.FileCount)
+		} else {
+			fmt.Fprintf(stdout, "%-24s %-12s %7s (%2.2f%%) in %d files\n",
+				key.module, key.language, showLines(r.LineCount),
+				float64(r.LineCount)*100.0/float64(total), r.FileCount)
+		}
+	}
+}
+
+// showLines - format a line count for the text reports: in thousands
+// with -ksloc, and with digit groups marked with -thousands
+func showLines(n uint64) string {
+	if !ksloc {
+		return showCount(n)
+	}
+	s := strconv.FormatFloat(float64(n)/1000, 'f', 1, 64)
+	if thousands {
+		point := strings.IndexByte(s, '.')
+		s = groupDigits(s[:point]) + s[point:]
+	}
+	return s
+}
+
+// showCount - format any other count for the text reports
+func showCount(n uint64) string {
+	s := strconv.FormatUint(n, 10)
+	if thousands {
+		s = groupDigits(s)
+	}
+	return s
+}
+
+// groupDigits - put commas between groups of three digits
+func groupDigits(digits string) string {
+	var out strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out.WriteByte(',')
+		}
+		out.WriteRune(c)
+	}
+	return out.String()
 }
 
-func reportCocomo(sloc uint) {
+// reportCocomo - estimate effort, schedule, and cost from a line
+// count, under a heading if there's a label
+func reportCocomo(label string, sloc uint64) {
 	const TIME_MULT = 2.4
 	const TIME_EXP = 1.05
 	const SCHED_MULT = 2.5
 	const SCHED_EXP = 0.38
 	const SALARY = 60384 // From payscale.com, late 2016
 	const OVERHEAD = 2.40
-	fmt.Printf("Total Physical Source Lines of Code (SLOC)                = %d\n", sloc)
+	if label != "" {
+		fmt.Fprintf(stdout, "%s:\n", label)
+	}
+	if ksloc {
+		fmt.Fprintf(stdout, "Total Physical Source Lines of Code, Thousands (KSLOC)    = %s\n", showLines(sloc))
+	} else {
+		fmt.Fprintf(stdout, "Total Physical Source Lines of Code (SLOC)                = %s\n", showLines(sloc))
+	}
 	personMonths := TIME_MULT * math.Pow(float64(sloc)/1000, TIME_EXP)
-	fmt.Printf("Development Effort Estimate, Person-Years (Person-Months) = %2.2f (%2.2f)\n", personMonths/12, personMonths)
-	fmt.Printf(" (Basic COCOMO model, Person-Months = %2.2f * (KSLOC**%2.2f))\n", TIME_MULT, TIME_EXP)
+	fmt.Fprintf(stdout, "Development Effort Estimate, Person-Years (Person-Months) = %2.2f (%2.2f)\n", personMonths/12, personMonths)
+	fmt.Fprintf(stdout, " (Basic COCOMO model, Person-Months = %2.2f * (KSLOC**%2.2f))\n", TIME_MULT, TIME_EXP)
 	schedMonths := SCHED_MULT * math.Pow(personMonths, SCHED_EXP)
-	fmt.Printf("Schedule Estimate, Years (Months)                         = %2.2f (%2.2f)\n", schedMonths/12, schedMonths)
-	fmt.Printf(" (Basic COCOMO model, Months = %2.2f * (person-months**%2.2f))\n", SCHED_MULT, SCHED_EXP)
-	fmt.Printf("Estimated Average Number of Developers (Effort/Schedule)  = %2.2f\n", personMonths/schedMonths)
-	fmt.Printf("Total Estimated Cost to Develop                           = $%d\n", int(SALARY*(personMonths/12)*OVERHEAD))
-	fmt.Printf(" (average salary = $%d/year, overhead = %2.2f).\n", SALARY, OVERHEAD)
+	fmt.Fprintf(stdout, "Schedule Estimate, Years (Months)                         = %2.2f (%2.2f)\n", schedMonths/12, schedMonths)
+	fmt.Fprintf(stdout, " (Basic COCOMO model, Months = %2.2f * (person-months**%2.2f))\n", SCHED_MULT, SCHED_EXP)
+	fmt.Fprintf(stdout, "Estimated Average Number of Developers (Effort/Schedule)  = %2.2f\n", personMonths/schedMonths)
+	fmt.Fprintf(stdout, "Total Estimated Cost to Develop                           = $%s\n", showCount(uint64(SALARY*(personMonths/12)*OVERHEAD)))
+	fmt.Fprintf(stdout, " (average salary = $%s/year, overhead = %2.2f).\n", showCount(SALARY), OVERHEAD)
 }
 
 func listLanguages() []string {
-	var names []string = []string{"python", "waf", "perl"}
+	var names []string = []string{"python", "waf", "raku", "perl", "ruby", "gdscript",
+		"coffeescript", "livescript", "f#", "julia", "ada", "abap", "sas", "d", "smalltalk", "jsx", "gherkin", "ini", "hcl", "nginx", "apache", "xml", "latex"}
 	var lastlang string
 	for i := range genericLanguages {
 		lang := genericLanguages[i].name
@@ -1562,69 +5892,449 @@ func listLanguages() []string {
 		}
 	}
 
-	for i := range scriptingLanguages {
-		lang := scriptingLanguages[i].name
-		if lang != lastlang {
-			names = append(names, lang)
-			lastlang = lang
-		}
+	for i := range scriptingLanguages {
+		lang := scriptingLanguages[i].name
+		if lang != lastlang {
+			names = append(names, lang)
+			lastlang = lang
+		}
+	}
+
+	for i := range pascalLikes {
+		lang := pascalLikes[i].name
+		if lang != lastlang {
+			names = append(names, lang)
+			lastlang = lang
+		}
+	}
+
+	for i := range fortranLikes {
+		lang := fortranLikes[i].name
+		if lang != lastlang {
+			names = append(names, lang)
+			lastlang = lang
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// languageExtensions - map each language to the extensions it claims
+func languageExtensions() map[string][]string {
+	extensions := map[string][]string{
+		"python":       {".py"},
+		"waf":          {"waf"},
+		"raku":         {".raku", ".rakumod", ".rakutest", ".p6", ".pl6", ".pm6"},
+		"perl":         {".pl", ".pm", ".ph"},
+		"ruby":         {".rb"},
+		"gdscript":     {".gd"},
+		"coffeescript": {".coffee"},
+		"livescript":   {".ls"},
+		"f#":           {".fs", ".fsi", ".fsx"},
+		"julia":        {".jl"},
+		"ada":          {".ada", ".adb", ".ads", ".pad"},
+		"abap":         {".abap"},
+		"sas":          {".sas"},
+		"d":            {".d"},
+		"smalltalk":    {".st"},
+		"jsx":          {".jsx"},
+		"gherkin":      {".feature"},
+		"ini":          {".ini", ".cfg", ".editorconfig"},
+		"hcl":          {".tf", ".tf.json", ".hcl"},
+		"nginx":        {"nginx.conf", ".conf"},
+		"apache":       {"httpd.conf", "apache.conf", "apache2.conf", ".conf"},
+		"xml":          {".xml", ".xsd", ".wsdl"},
+		"latex":        {".tex", ".ltx", ".sty", ".cls"},
+	}
+	for i := range genericLanguages {
+		lang := genericLanguages[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range scriptingLanguages {
+		lang := scriptingLanguages[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range pascalLikes {
+		lang := pascalLikes[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range fortranLikes {
+		lang := fortranLikes[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	var dotfiles []string
+	for name := range shellDotfiles {
+		dotfiles = append(dotfiles, name)
+	}
+	sort.Strings(dotfiles)
+	for _, name := range dotfiles {
+		lang := shellDotfiles[name]
+		extensions[lang] = append(extensions[lang], name)
+	}
+
+	// Components count as whichever language their scripts are in.
+	for _, lang := range []string{"javascript", "typescript"} {
+		extensions[lang] = append(extensions[lang], ".svelte", ".astro")
+	}
+	return extensions
+}
+
+func listExtensions() {
+	extensions := languageExtensions()
+	names := listLanguages()
+	for i := range names {
+		fmt.Printf("%s: %v\n", names[i], extensions[names[i]])
+	}
+}
+
+type sortable []countRecord
+
+func (a sortable) Len() int           { return len(a) }
+func (a sortable) Swap(i int, j int)  { a[i], a[j] = a[j], a[i] }
+func (a sortable) Less(i, j int) bool { return -a[i].LineCount < -a[j].LineCount }
+
+// summaryLanguage - the language a file is tallied under in a
+// summary.  C++-looking headers are set aside until we know whether
+// there's anything to fold them into.
+func summaryLanguage(st SourceStat) string {
+	lang := st.Language
+	if st.cplusplus {
+		lang = "c++-header"
+	} else if st.objc {
+		lang = "obj-c-header"
+	}
+	if st.migration {
+		lang += "-migrations"
+	}
+	return lang
+}
+
+// foldHeaders - fold the header tallies of a summary into the
+// languages they belong with, C headers going to the first of the
+// priority languages present
+func foldHeaders(counts map[string]countRecord, priority []string, into string, noMerge bool) {
+	// Headers go where the user says, if they say; with nowhere
+	// to go, the ones that look like C++ stay with the rest.
+	if into != "" || noMerge {
+		if into == "" {
+			into = "c-header"
+		}
+		for _, hdr := range []string{"c-header", "c++-header", "obj-c-header"} {
+			if rec, ok := counts[hdr]; ok && hdr != into {
+				var tmp = counts[into]
+				tmp.Language = into
+				tmp.mergeLines(rec)
+				tmp.FileCount += rec.FileCount
+				counts[into] = tmp
+				delete(counts, hdr)
+			}
+		}
+	}
+	// C headers may get reassigned based on what other languages
+	// are present in the tree
+	if !noMerge && (counts["c-header"].LineCount > 0 || counts["c++-header"].LineCount > 0) {
+		for i := range priority {
+			if counts[priority[i]].LineCount > 0 {
+				var tmp = counts[priority[i]]
+				tmp.mergeLines(counts["c-header"])
+				tmp.mergeLines(counts["c++-header"])
+				counts[priority[i]] = tmp
+				delete(counts, "c-header")
+				delete(counts, "c++-header")
+				break
+			}
+		}
+	}
+	// In a header-only library, headers that look like C++ are C++.
+	if hdr, ok := counts["c++-header"]; ok {
+		var tmp = counts["c++"]
+		tmp.Language = "c++"
+		tmp.mergeLines(hdr)
+		tmp.FileCount += hdr.FileCount
+		counts["c++"] = tmp
+		delete(counts, "c++-header")
+	}
+	// Headers that look like Objective-C are Objective-C, whatever
+	// else is in the tree.
+	if hdr, ok := counts["obj-c-header"]; ok {
+		var tmp = counts["obj-c"]
+		tmp.Language = "obj-c"
+		tmp.mergeLines(hdr)
+		tmp.FileCount += hdr.FileCount
+		counts["obj-c"] = tmp
+		delete(counts, "obj-c-header")
+	}
+}
+
+// byFiles - order summary records by file count, then by lines
+type byFiles struct{ sortable }
+
+func (a byFiles) Less(i, j int) bool {
+	if a.sortable[i].FileCount != a.sortable[j].FileCount {
+		return a.sortable[i].FileCount > a.sortable[j].FileCount
+	}
+	return a.sortable.Less(i, j)
+}
+
+// Fixtures state their expected count in a comment near the top.
+var expectation = regexp.MustCompile(`Should count (?:as )?(?:only )?(\d+) lines?`)
+
+// selftest - count every fixture under the roots that states what it
+// should count, and complain about any that disagree.  Silence is
+// success.  Returns the number of failures.
+func selftest(roots []string) int {
+	var checked, failed int
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			ctx := new(countContext)
+			if !ctx.setup(path) {
+				return nil
+			}
+			var want []byte
+			for i := 0; i < 20 && want == nil && ctx.munchline(); i++ {
+				if m := expectation.FindSubmatch(ctx.line); m != nil {
+					want = m[1]
+				}
+			}
+			ctx.teardown()
+			if want == nil {
+				return nil
+			}
+			checked++
+			st := Generic(new(countContext), path)
+			if strconv.FormatUint(st.SLOC, 10) != string(want) {
+				fmt.Printf("%s: expected %s lines, counted %d as %q\n",
+					path, want, st.SLOC, st.Language)
+				failed++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		failed += selftestTables(filepath.Join(root, "tables"))
+	}
+	failed += selftestStream()
+	failed += selftestTotals()
+	if failed > 0 {
+		fmt.Printf("%d of %d fixtures failed\n", failed, checked)
+	}
+	return failed
+}
+
+// selftestTables - count the tree in dir, if there is one, under the
+// default tables and under a set knowing only made-up languages, both
+// at once, and complain about any file counted by the wrong rules.
+// Returns the number of failures.
+func selftestTables(dir string) int {
+	if !isDirectory(dir) {
+		return 0
+	}
+	custom := LanguageTables{
+		Generic: []genericLanguage{
+			{"k", ".k", "", "", "#", "", false, false, nil},
+			// Delimiters of a single character take a path
+			// through the C-family counter that no built-in
+			// language does.
+			{"kc", ".kc", "{", "}", "%", "", false, false, nil},
+		},
+	}
+	runs := []struct {
+		name   string
+		tables LanguageTables
+		want   map[string]string // language and count by file
+	}{
+		{"default", DefaultTables(), map[string]string{
+			"hello.c": "c 4", "notes.k": " 0", "block.kc": " 0"}},
+		{"custom", custom, map[string]string{
+			"hello.c": " 0", "notes.k": "k 2", "block.kc": "kc 4"}},
+	}
+	got := make([][]SourceStat, len(runs))
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], _ = CountWith(runs[i].tables, dir)
+		}(i)
 	}
+	wg.Wait()
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i].name
-		if lang != lastlang {
-			names = append(names, lang)
-			lastlang = lang
+	var failed int
+	for i, run := range runs {
+		seen := map[string]string{}
+		for _, st := range got[i] {
+			seen[filepath.Base(st.Path)] = fmt.Sprintf("%s %d", st.Language, st.SLOC)
+		}
+		for name, want := range run.want {
+			if seen[name] != want {
+				fmt.Printf("%s: expected %q under the %s tables, counted %q\n",
+					filepath.Join(dir, name), want, run.name, seen[name])
+				failed++
+			}
 		}
 	}
+	return failed
+}
 
-	for i := range fortranLikes {
-		lang := fortranLikes[i].name
-		if lang != lastlang {
-			names = append(names, lang)
-			lastlang = lang
+// selftestTotals - sum counts past what 32 bits hold, through the
+// same tallying the reports use, and complain if any total wraps.
+// Returns the number of failures.
+func selftestTotals() int {
+	const big, files = uint64(1) << 31, 5
+	want := big * files // 10737418240, past 2^32
+	var lang, totals countRecord
+	for i := 0; i < files; i++ {
+		st := SourceStat{Path: fmt.Sprintf("huge%d.c", i), Language: "c",
+			SLOC: big, TotalLines: big, Bytes: big}
+		lang.add(st, true)
+	}
+	totals.mergeLines(lang)
+	totals.FileCount += lang.FileCount
+	var failed int
+	for _, got := range []struct {
+		name  string
+		count uint64
+	}{
+		{"lines", lang.LineCount},
+		{"all lines", lang.TotalLines},
+		{"bytes", lang.Bytes},
+		{"merged lines", totals.LineCount},
+	} {
+		if got.count != want {
+			fmt.Printf("totals: expected %d %s, summed %d\n", want, got.name, got.count)
+			failed++
 		}
 	}
-	sort.Strings(names)
-	return names
+	if totals.FileCount != files {
+		fmt.Printf("totals: expected %d files, summed %d\n", files, totals.FileCount)
+		failed++
+	}
+	if _, _, _, most := fileSizes(lang.files); most != big {
+		fmt.Printf("totals: expected a largest file of %d lines, found %d\n", big, most)
+		failed++
+	}
+	return failed
 }
 
-func listExtensions() {
-	extensions := map[string][]string{
-		"python": {".py"},
-		"waf":    {"waf"},
-		"perl":   {"pl", "pm"},
-	}
-	for i := range genericLanguages {
-		lang := genericLanguages[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+// streamRecorder - a writer that keeps what it's given and notices
+// when two writes overlap
+type streamRecorder struct {
+	busy     sync.Mutex
+	lock     sync.Mutex
+	writes   [][]byte
+	overlaps int
+}
+
+func (r *streamRecorder) Write(p []byte) (int, error) {
+	if !r.busy.TryLock() {
+		r.lock.Lock()
+		r.overlaps++
+		r.lock.Unlock()
+		return len(p), nil
 	}
+	defer r.busy.Unlock()
+	time.Sleep(time.Microsecond) // give a racing writer its chance
+	r.lock.Lock()
+	r.writes = append(r.writes, append([]byte(nil), p...))
+	r.lock.Unlock()
+	return len(p), nil
+}
 
-	for i := range scriptingLanguages {
-		lang := scriptingLanguages[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+// selftestStream - have many goroutines write stream events at once,
+// with paths no JSON string can carry verbatim, and complain about
+// any write that isn't one whole, valid event.  Returns the number of
+// failures.
+func selftestStream() int {
+	const writers, events = 8, 50
+	var r streamRecorder
+	s := NewJSONStreamWriter(&r)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < events; j++ {
+				path := fmt.Sprintf("dir\x01%d/\"quoted\xff\"\n%d.c", i, j)
+				if j%2 == 0 {
+					s.File(SourceStat{Path: path, Language: "c", SLOC: uint64(j)})
+				} else {
+					s.Error(path, errors.New("bad\x00path"))
+				}
+			}
+		}(i)
 	}
+	wg.Wait()
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	var failed int
+	if r.overlaps > 0 {
+		fmt.Printf("stream: %d writes overlapped\n", r.overlaps)
+		failed++
 	}
+	if len(r.writes) != writers*events {
+		fmt.Printf("stream: expected %d events, saw %d\n", writers*events, len(r.writes))
+		failed++
+	}
+	for _, w := range r.writes {
+		if bytes.Count(w, []byte("\n")) != 1 || !bytes.HasSuffix(w, []byte("\n")) || !json.Valid(w) {
+			fmt.Printf("stream: not one whole event: %q\n", w)
+			failed++
+		}
+	}
+	return failed
+}
 
-	for i := range fortranLikes {
-		lang := fortranLikes[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+// Reports go through a buffer, flushed every so often so that a
+// pipeline downstream sees steady progress through a large tree.
+var stdout = bufio.NewWriter(os.Stdout)
+
+const flushInterval = 100 * time.Millisecond
+
+// flushOutput - push buffered output downstream.  A reader that has
+// gone away, as when output is piped to head(1), is not an error
+// worth a message; there's nobody left to report to, so just stop.
+func flushOutput() {
+	if err := stdout.Flush(); err != nil {
+		if errors.Is(err, syscall.EPIPE) {
+			exit(0)
+		}
+		fatal(err)
 	}
-	names := listLanguages()
-	for i := range names {
-		fmt.Printf("%s: %v\n", names[i], extensions[names[i]])
+}
+
+// The temporary directory a -url archive was unpacked into, if any.
+// Deferred calls don't run on the way out through os.Exit, so exits
+// once counting has begun go through exit or fatal, which remove it.
+var fetched string
+
+// exit - remove what -url unpacked and quit with the given status
+func exit(status int) {
+	if fetched != "" {
+		os.RemoveAll(fetched)
 	}
+	os.Exit(status)
 }
 
-type sortable []countRecord
+// fatal - log.Fatal, but removing what -url unpacked first
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	exit(1)
+}
 
-func (a sortable) Len() int           { return len(a) }
-func (a sortable) Swap(i int, j int)  { a[i], a[j] = a[j], a[i] }
-func (a sortable) Less(i, j int) bool { return -a[i].linecount < -a[j].linecount }
+// jsonString - a string as a JSON literal.  Go's %q is not JSON: it
+// writes control characters and invalid UTF-8 as \x escapes, which
+// no JSON parser will take.
+func jsonString(str string) string {
+	literal, _ := json.Marshal(str)
+	return string(literal)
+}
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
@@ -1634,28 +6344,192 @@ func main() {
 	var list bool
 	var extensions bool
 	var cocomo bool
-	var json bool
+	var cocomoPerLang bool
+	var jsonOut bool
+	var jsonPretty bool
+	var hashing bool
+	var streaming bool
+	var strict bool
 	var showversion bool
+	var format string
+	var dedup bool
+	var ambiguous bool
+	var hidden bool
+	var top int
+	var oneshot bool
+	var detailed bool
+	var sorted bool
+	var sortFiles bool
+	var selftesting bool
+	var shellDialects bool
+	var noMergeHeaders bool
+	var migrations bool
+	var showConfidence bool
+	var nonGenerated bool
 	excludePtr := flag.String("x", "",
 		"paths and directories to exclude")
+	excludeLangPtr := flag.String("exclude-lang", "",
+		"languages to leave out of the report")
+	extOnlyPtr := flag.String("ext-only", "",
+		"consider only files with these extensions")
+	ignoreExtPtr := flag.String("ignore-ext", "",
+		"additional file extensions to ignore")
+	ignoreNamePtr := flag.String("ignore-name", "",
+		"additional file basenames to ignore")
+	relativePtr := flag.String("relative-to", "",
+		"report paths relative to this directory")
+	percentPtr := flag.String("relative-percentage", "all",
+		"denominator of percentages: all, non-test, or non-generated")
+	asmCommentPtr := flag.String("asm-comment", "",
+		"winged-comment leader for assembler files (default ;)")
+	mergePtr := flag.String("merge", "",
+		"collapse languages matching glob=name pairs into one")
+	headersIntoPtr := flag.String("merge-headers-into", "",
+		"always count C headers as this language")
+	migrationPtr := flag.String("migration-patterns", "",
+		"globs naming migration files, replacing the defaults")
+	generatedPtr := flag.String("generated-patterns", "",
+		"globs naming generated files, replacing the defaults")
 	flag.BoolVar(&individual, "i", false,
 		"list counts and types for individual files")
 	flag.BoolVar(&unclassified, "u", false,
 		"list unclassified files")
+	flag.BoolVar(&showConfidence, "confidence", false,
+		"with -i, show how sure a verifier was of each file's language")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"list what the filters pass and how it would be classified, counting nothing")
+	flag.BoolVar(&skipTestdata, "skip-testdata", false,
+		"skip directories named testdata, as the go tool does")
+	urlPtr := flag.String("url", "",
+		"also count the tarball at a URL, unpacked to a temporary directory")
+	urlTimeoutPtr := flag.Duration("url-timeout", 5*time.Minute,
+		"how long -url may take to download")
+	urlMaxPtr := flag.Int64("url-max-mb", 1024,
+		"most megabytes -url may unpack")
+	embedPtr := flag.Bool("skip-embedded", false,
+		"don't count files that Go sources pull in with //go:embed")
+	flag.BoolVar(&countGenerated, "count-generated", false,
+		"count files named like generated code, such as *.pb.go")
+	flag.BoolVar(&langStats, "lang-stats", false,
+		"show the mean, median, least, and greatest file size of each language")
+	flag.BoolVar(&ksloc, "ksloc", false,
+		"report line counts in thousands in the text summary")
+	flag.BoolVar(&thousands, "thousands", false,
+		"group digits with commas in the text summary")
+	flag.BoolVar(&commentOnly, "comment-only", false,
+		"with -i, also list files holding comments but no code, marked comment-only")
+	flag.BoolVar(&streaming, "json-stream", false,
+		"stream newline-delimited JSON events as files are counted")
+	flag.BoolVar(&strict, "strict", false,
+		"exit with status 1 if any file ends inside a comment or string")
+	flag.BoolVar(&hashing, "hash", false,
+		"finish with a SHA-256 hash of the files counted and their counts")
+	flag.BoolVar(&countEmpty, "count-empty", false,
+		"with -i, also list recognized files holding no code")
 	flag.BoolVar(&cocomo, "c", false,
 		"report Cocomo-model estimation")
+	flag.BoolVar(&cocomoPerLang, "cocomo-per-lang", false,
+		"report a Cocomo-model estimation for each language, then for all")
 	flag.BoolVar(&list, "l", false,
 		"list supported languages and exit")
 	flag.BoolVar(&extensions, "e", false,
 		"list extensions associated with each language and exit")
 	flag.IntVar(&debug, "d", 0,
 		"set debug level")
-	flag.BoolVar(&json, "j", false,
+	flag.BoolVar(&jsonOut, "j", false,
 		"dump statistics in JSON format")
+	flag.BoolVar(&jsonPretty, "json-pretty", false,
+		"like -j, but indent each record")
 	flag.BoolVar(&showversion, "V", false,
 		"report version and exit")
+	flag.StringVar(&format, "format", "",
+		"text/template for each summary line")
+	flag.BoolVar(&ambiguous, "ambiguity", false,
+		"warn about files whose language is uncertain")
+	flag.BoolVar(&followSymlinks, "L", false,
+		"follow symbolic links to directories, walking each once")
+	flag.BoolVar(&dedup, "dedup", false,
+		"count files with identical contents only once")
+	flag.BoolVar(&hidden, "hidden", false,
+		"count hidden files and directories")
+	flag.IntVar(&top, "top", 0,
+		"show only the N largest languages")
+	flag.BoolVar(&fortranLogical, "fortran-logical", false,
+		"count Fortran statements rather than lines")
+	flag.BoolVar(&oneshot, "oneshot", false,
+		"print only the SLOC of a single file")
+	flag.BoolVar(&selftesting, "selftest", false,
+		"check the counts of fixtures that state what they should count")
+	flag.BoolVar(&detailed, "detailed", false,
+		"with -j, list contributing files under each language")
+	flag.BoolVar(&splitData, "data", false,
+		"count embedded data such as Perl __DATA__ separately from code")
+	flag.BoolVar(&countPreproc, "preproc", false,
+		"tally C preprocessor directive lines separately")
+	flag.BoolVar(&byModule, "bymodule", false,
+		"summarize code lines by the module or package they belong to")
+	flag.BoolVar(&blaming, "blame", false,
+		"summarize code lines by last author, using git blame")
+	flag.BoolVar(&findSQL, "embedded-sql", false,
+		"experimental: also tally string literals that look like SQL")
+	flag.BoolVar(&migrations, "migrations", false,
+		"report schema migrations apart from other code in each language")
+	flag.BoolVar(&gunzip, "gzip", false,
+		"count gzipped files by the language of their inner name")
+	flag.BoolVar(&countAPI, "api", false,
+		"also count declaration lines outside function bodies")
+	flag.BoolVar(&countEffective, "effective", false,
+		"also count C-family statements wrapped across lines once")
+	flag.BoolVar(&countChars, "chars", false,
+		"also report bytes and UTF-8 characters")
+	flag.BoolVar(&indenting, "indent", false,
+		"also report the mean indentation of code lines")
+	flag.BoolVar(&commentDensity, "comment-density", false,
+		"also report the share of code lines carrying a comment")
+	tabPtr := flag.String("tab-width", "8",
+		"columns between tab stops for -indent, as n or lang=n,...")
+	flag.BoolVar(&docstringsAsCode, "docstrings-as-code", false,
+		"count Python docstrings as code rather than comments")
+	flag.BoolVar(&allLines, "all-lines", false,
+		"also report all lines, including comments and blanks")
+	flag.BoolVar(&sorted, "sorted", false,
+		"with -i or -u, sort output by path instead of walking serially")
+	flag.BoolVar(&noMergeHeaders, "no-merge-headers", false,
+		"report C headers as c-header rather than folding them in")
+	flag.BoolVar(&shellDialects, "shell-dialects", false,
+		"report bash, zsh, ksh, and fish apart from other shell")
+	flag.BoolVar(&sortFiles, "sort-files", false,
+		"order the summary by file count rather than by lines")
+	flag.BoolVar(&iniSections, "ini-sections", false,
+		"count INI section headers as lines of code")
+	flag.BoolVar(&noCountBraces, "no-count-braces", false,
+		"don't count C-family lines holding only braces and semicolons")
+	flag.BoolVar(&noCountRegions, "no-count-regions", false,
+		"don't count #region and #pragma region folding markers")
+	flag.BoolVar(&countTeX, "count-tex", false,
+		"count TeX and LaTeX sources")
+	flag.BoolVar(&countXML, "xml", false,
+		"count XML, XSD, and WSDL markup")
 	flag.Parse()
 
+	if jsonPretty {
+		jsonOut = true
+	}
+
+	// Under -strict, files that end inside a comment or string fail
+	// the run, once everything else has been reported.
+	var problems []string
+	defer func() {
+		if len(problems) > 0 {
+			sort.Strings(problems)
+			fmt.Fprintf(os.Stderr, "unterminated comments or strings: %d\n", len(problems))
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			exit(1)
+		}
+	}()
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -1675,6 +6549,37 @@ func main() {
 		return
 	}
 
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		unescape := strings.NewReplacer("\\n", "\n", "\\t", "\t")
+		tmpl, err = template.New("format").Parse(unescape.Replace(format))
+		if err != nil {
+			log.Fatalf("malformed -format template: %v", err)
+		}
+	}
+
+	// A single file gets just its count, suitable for $(...) capture.
+	if oneshot {
+		if flag.NArg() != 1 || !isRegular(flag.Arg(0)) {
+			log.Fatal("-oneshot requires exactly one regular file")
+		}
+		st := Generic(new(countContext), flag.Arg(0))
+		fmt.Println(st.SLOC)
+		return
+	}
+
+	if selftesting {
+		roots := flag.Args()
+		if len(roots) == 0 {
+			roots = []string{"tests"}
+		}
+		if selftest(roots) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	individual = individual || unclassified
 
 	// For maximum performance, make the pipeline be as deep as the
@@ -1682,18 +6587,207 @@ func main() {
 	// be running full-out exactly when it's filled and no sooner.
 	// This makes order of output nondeterministic, which is why
 	// we sometimes want to disable it.
+	// With -sorted we buffer and sort instead, keeping the parallelism.
 	var chandepth int
-	if individual || unclassified {
+	if (individual || unclassified) && !sorted {
 		chandepth = 0
 	} else {
 		chandepth = runtime.NumCPU()
 	}
 	pipeline = make(chan SourceStat, chandepth)
 
+	if ambiguous {
+		ambiguity = newAmbiguityChecker()
+		defer ambiguity.report()
+	}
+	// Make a closed pipe show up as EPIPE rather than killing us.
+	signal.Ignore(syscall.SIGPIPE)
+	defer flushOutput()
+	if dedup {
+		duplicates = newDupDetector()
+	}
+
+	type merger struct{ pattern, name string }
+	var mergers []merger
+	if len(*mergePtr) > 0 {
+		for _, pair := range strings.Split(*mergePtr, ",") {
+			fields := strings.SplitN(pair, "=", 2)
+			if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+				log.Fatalf("-merge wants pattern=name, not %q", pair)
+			}
+			if _, err := filepath.Match(fields[0], ""); err != nil {
+				log.Fatalf("-merge pattern %q: %v", fields[0], err)
+			}
+			mergers = append(mergers, merger{fields[0], fields[1]})
+		}
+	}
+
+	if len(*migrationPtr) > 0 {
+		for _, pattern := range strings.Split(*migrationPtr, ",") {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				log.Fatalf("-migration-patterns %q: %v", pattern, err)
+			}
+			migrationPatterns = append(migrationPatterns, pattern)
+		}
+	} else if migrations {
+		migrationPatterns = defaultMigrationPatterns
+	}
+	if len(*generatedPtr) > 0 {
+		for _, pattern := range strings.Split(*generatedPtr, ",") {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				log.Fatalf("-generated-patterns %q: %v", pattern, err)
+			}
+			generatedPatterns = append(generatedPatterns, pattern)
+		}
+	} else {
+		generatedPatterns = defaultGeneratedPatterns
+	}
+
+	tabWidths = map[string]int{"": 8}
+	for _, width := range strings.Split(*tabPtr, ",") {
+		lang, n := "", width
+		if i := strings.Index(width, "="); i > -1 {
+			lang, n = width[:i], width[i+1:]
+		}
+		columns, err := strconv.Atoi(n)
+		if err != nil || columns < 1 {
+			log.Fatalf("-tab-width wants a positive number of columns, not %q", width)
+		}
+		tabWidths[lang] = columns
+	}
+
+	switch *percentPtr {
+	case "all":
+	case "non-generated":
+		// Only files named as generated, and only with
+		// -count-generated, make this differ from all.
+		nonGenerated = true
+	case "non-test":
+		splitTests = true
+	default:
+		log.Fatalf("-relative-percentage wants all, non-test, or non-generated, not %q", *percentPtr)
+	}
+
+	if *relativePtr != "" {
+		var err error
+		relativeBase, err = filepath.Abs(*relativePtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if countXML {
+		delete(neverInterestingBySuffix, ".xml")
+	}
+	if countTeX {
+		delete(neverInterestingBySuffix, ".tex")
+	}
+	if gunzip {
+		delete(neverInterestingBySuffix, ".gz")
+	}
+
+	if hidden {
+		// Drop the filters that reject dotfiles and dot-directories.
+		neverInterestingByPrefix = nil
+		var infixes []string
+		for _, infix := range neverInterestingByInfix {
+			if infix != "/." {
+				infixes = append(infixes, infix)
+			}
+		}
+		neverInterestingByInfix = infixes
+	}
+
 	if len(*excludePtr) > 0 {
 		exclusions = strings.Split(*excludePtr, ",")
 	}
+	// Assembler dialects disagree about the comment leader; GNU as
+	// uses # on x86 and @ on ARM, where # can also mark immediates.
+	if *asmCommentPtr != "" {
+		for i := range genericLanguages {
+			if genericLanguages[i].name == "asm" {
+				genericLanguages[i].eolcomment = *asmCommentPtr
+			}
+		}
+	}
+
+	// Unless asked to tell them apart, shell dialects are all shell.
+	if !shellDialects {
+		for i := range scriptingLanguages {
+			switch scriptingLanguages[i].name {
+			case "bash", "zsh", "ksh", "fish":
+				scriptingLanguages[i].name = "shell"
+			}
+		}
+		for name, lang := range shellDotfiles {
+			switch lang {
+			case "bash", "zsh", "ksh":
+				shellDotfiles[name] = "shell"
+			}
+		}
+	}
+
+	excludedLangs := map[string]bool{}
+	if len(*excludeLangPtr) > 0 {
+		for _, lang := range strings.Split(*excludeLangPtr, ",") {
+			excludedLangs[lang] = true
+		}
+	}
+	if len(*extOnlyPtr) > 0 {
+		for _, ext := range strings.Split(*extOnlyPtr, ",") {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			extOnly = append(extOnly, ext)
+		}
+	}
+	if len(*ignoreExtPtr) > 0 {
+		for _, ext := range strings.Split(*ignoreExtPtr, ",") {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if strings.Count(ext, ".") > 1 {
+				neverInterestingByCompoundSuffix = append(neverInterestingByCompoundSuffix, ext)
+			} else {
+				neverInterestingBySuffix[ext] = true
+			}
+		}
+	}
+	if len(*ignoreNamePtr) > 0 {
+		for _, name := range strings.Split(*ignoreNamePtr, ",") {
+			neverInterestingByBasename[strings.ToLower(name)] = true
+		}
+	}
 	roots := flag.Args()
+	if *urlPtr != "" {
+		root, tmp, err := fetchTarball(*urlPtr, *urlTimeoutPtr, *urlMaxPtr<<20)
+		if err != nil {
+			log.Fatalf("-url %s: %v", *urlPtr, err)
+		}
+		fetched = tmp
+		defer os.RemoveAll(tmp)
+		roots = append(roots, root)
+	}
+	if *embedPtr {
+		embedded = findEmbedded(roots)
+	}
+
+	// Events go out as they happen, not through the report buffer.
+	if streaming {
+		stream := NewJSONStreamWriter(os.Stdout)
+		stream.Follow = followSymlinks
+		stream.HeadersInto = *headersIntoPtr
+		stream.NoMergeHeaders = noMergeHeaders
+		for _, root := range roots {
+			if err := stream.Count(DefaultTables(), root); err != nil {
+				if errors.Is(err, syscall.EPIPE) {
+					exit(0)
+				}
+				fatal(err)
+			}
+		}
+		return
+	}
 
 	here, _ := os.Getwd()
 	go func() {
@@ -1701,14 +6795,76 @@ func main() {
 			os.Chdir(roots[i])
 			// The system filepath.Walk() works here,
 			// but is slower.
-			Walk(".", filter)
+			WalkWith(context.Background(), ".", WalkOptions{FollowSymlinks: followSymlinks}, filter)
 			os.Chdir(here)
 		}
 		close(pipeline)
 	}()
 
 	var totals countRecord
+	var production uint64 // lines outside test suites, for non-test percentages
 	counts := map[string]countRecord{}
+	var buffered []SourceStat
+	var hashed []SourceStat // files counted, for -hash
+	authors := map[string]uint64{}
+	modules := map[moduleKey]countRecord{}
+	flushed := time.Now()
+	// Files named as generated are left out, but tallied.
+	var generatedFiles countRecord
+	var handwritten uint64 // lines in all other files, for non-generated percentages
+
+	// A recognized file with no code is not unclassified, but
+	// it's only reported when asked for.
+	emptyFile := func(st SourceStat) bool {
+		return (countEmpty && st.Classified && st.SLOC == 0) || (commentOnly && st.comments)
+	}
+
+	reportIndividual := func(st SourceStat) {
+		if !unclassified && (st.SLOC > 0 || emptyFile(st)) {
+			fmt.Fprintf(stdout, "%s %d %s", st.Path, st.SLOC, st.Language)
+			if splitData {
+				fmt.Fprintf(stdout, " %d", st.Data)
+			}
+			if countPreproc {
+				fmt.Fprintf(stdout, " %d", st.Preproc)
+			}
+			if allLines {
+				fmt.Fprintf(stdout, " %d", st.TotalLines)
+			}
+			if countEffective {
+				fmt.Fprintf(stdout, " %d", st.Effective)
+			}
+			if countAPI {
+				fmt.Fprintf(stdout, " %d", st.API)
+			}
+			if findSQL {
+				fmt.Fprintf(stdout, " %d", st.EmbeddedSQL)
+			}
+			if countChars {
+				fmt.Fprintf(stdout, " %d %d", st.Bytes, st.Runes)
+			}
+			if indenting {
+				fmt.Fprintf(stdout, " %.1f", meanIndent(st.Indent, st.SLOC))
+			}
+			if commentDensity {
+				fmt.Fprintf(stdout, " %.2f", commentShare(st.Commented, st.SLOC))
+			}
+			if showConfidence && st.Confidence > 0 {
+				fmt.Fprintf(stdout, " %.2f", st.Confidence)
+			} else if showConfidence {
+				// No verifier had to settle this one.
+				fmt.Fprint(stdout, " -")
+			}
+			if commentOnly && st.comments {
+				fmt.Fprint(stdout, " comment-only")
+			}
+			fmt.Fprint(stdout, "\n")
+		} else if unclassified && st.SLOC == 0 && !emptyFile(st) {
+			// Not a recognized source type,
+			// nor anything we know to discard
+			fmt.Fprintln(stdout, st.Path)
+		}
+	}
 
 	// Mainline resumes
 	for {
@@ -1716,80 +6872,358 @@ func main() {
 		if !more {
 			break
 		}
+		if dryRun {
+			if st.rejected == "" && excludedLangs[st.Language] {
+				st.rejected = "exclude-lang"
+			}
+			buffered = append(buffered, st)
+			continue
+		}
+		if excludedLangs[st.Language] {
+			continue
+		}
+		if st.generated && st.SLOC > 0 && !countGenerated {
+			generatedFiles.add(st, false)
+			continue
+		}
 		if debug > 0 {
 			fmt.Printf("from pipeline: %s %d %s\n",
 				st.Path, st.SLOC, st.Language)
 		}
+		if hashing && st.SLOC > 0 {
+			hashed = append(hashed, st)
+		}
+		if strict {
+			problems = append(problems, st.problems...)
+		}
 
-		if individual {
-			if !unclassified && st.SLOC > 0 {
-				fmt.Printf("%s %d %s\n",
-					st.Path, st.SLOC, st.Language)
-			} else if unclassified && st.SLOC == 0 {
-				// Not a recognized source type,
-				// nor anything we know to discard
-				fmt.Println(st.Path)
+		if individual && (sorted || (unclassified && jsonOut)) {
+			buffered = append(buffered, st)
+			continue
+		} else if individual {
+			reportIndividual(st)
+			if time.Since(flushed) > flushInterval {
+				flushOutput()
+				flushed = time.Now()
 			}
 			continue
 		}
 
 		if st.SLOC > 0 {
-			var tmp = counts[st.Language]
-			tmp.language = st.Language
-			tmp.linecount += st.SLOC
-			tmp.filecount++
-			counts[st.Language] = tmp
-			totals.linecount += st.SLOC
-			totals.filecount++
+			lang := summaryLanguage(st)
+			var tmp = counts[lang]
+			tmp.Language = lang
+			tmp.add(st, detailed || langStats)
+			counts[lang] = tmp
+			totals.add(st, false)
+			if !st.test {
+				production += st.SLOC
+			}
+			if !st.generated {
+				handwritten += st.SLOC
+			}
+			for author, n := range st.blame {
+				authors[author] += n
+			}
+			if byModule {
+				key := moduleKey{st.module, lang}
+				var tmp = modules[key]
+				tmp.Language = lang
+				tmp.add(st, false)
+				modules[key] = tmp
+			}
 		}
+		// Embedded SQL gets a row of its own, but those lines
+		// are already in the totals under the host language.
+		if st.EmbeddedSQL > 0 {
+			var tmp = counts["embedded-sql"]
+			tmp.Language = "embedded-sql"
+			tmp.LineCount += st.EmbeddedSQL
+			tmp.FileCount++
+			counts["embedded-sql"] = tmp
+		}
+	}
+
+	if dryRun {
+		reportDryRun(buffered)
+		return
+	}
+
+	// Whatever the report, the hash comes last.
+	if hashing {
+		defer func() {
+			if jsonOut {
+				fmt.Fprintf(stdout, "{\"sha256\":\"%x\"}\n", corpusHash(hashed))
+			} else {
+				fmt.Fprintf(stdout, "sha256 %x\n", corpusHash(hashed))
+			}
+		}()
 	}
 
 	if individual {
+		sort.SliceStable(buffered, func(i, j int) bool {
+			return buffered[i].Path < buffered[j].Path
+		})
+		if unclassified && jsonOut {
+			var rec bytes.Buffer
+			fmt.Fprint(&rec, "[")
+			n := 0
+			for _, st := range buffered {
+				if st.SLOC > 0 || emptyFile(st) {
+					continue
+				}
+				if n > 0 {
+					fmt.Fprint(&rec, ", ")
+				}
+				fmt.Fprintf(&rec, "{\"path\":%s, \"extension\":%s}",
+					jsonString(st.Path), jsonString(filepath.Ext(st.Path)))
+				n++
+			}
+			fmt.Fprint(&rec, "]")
+			if jsonPretty {
+				var indented bytes.Buffer
+				if json.Indent(&indented, rec.Bytes(), "", "  ") == nil {
+					rec = indented
+				}
+			}
+			fmt.Fprintf(stdout, "%s\n", rec.Bytes())
+			return
+		}
+		for _, st := range buffered {
+			reportIndividual(st)
+		}
 		return
 	}
 
-	// C headers may get reassigned based on what other languages
-	// are present in the tree
-	if counts["c-header"].linecount > 0 {
-		for i := range cHeaderPriority {
-			if counts[cHeaderPriority[i]].linecount > 0 {
-				var tmp = counts[cHeaderPriority[i]]
-				tmp.linecount += counts["c-header"].linecount
-				counts[cHeaderPriority[i]] = tmp
-				delete(counts, "c-header")
-				break
+	if blaming {
+		reportBlame(authors, jsonOut)
+		return
+	}
+
+	if byModule {
+		reportModules(modules, jsonOut)
+		return
+	}
+
+	// Collapse related languages under one name; first match wins.
+	if len(mergers) > 0 {
+		merged := map[string]countRecord{}
+		for lang, rec := range counts {
+			for _, m := range mergers {
+				if ok, _ := filepath.Match(m.pattern, lang); ok {
+					lang = m.name
+					break
+				}
 			}
+			var tmp = merged[lang]
+			tmp.Language = lang
+			tmp.mergeLines(rec)
+			tmp.FileCount += rec.FileCount
+			merged[lang] = tmp
 		}
+		counts = merged
 	}
 
+	foldHeaders(counts, DefaultTables().CHeaderPriority, *headersIntoPtr, noMergeHeaders)
+
 	var summary sortable
-	totals.language = "all"
-	if totals.filecount > 1 {
+	totals.Language = "all"
+	if totals.FileCount > 1 {
 		summary = append(summary, totals)
 	}
 	for _, v := range counts {
 		summary = append(summary, v)
 	}
 
-	sort.Sort(summary)
+	if sortFiles {
+		sort.Sort(byFiles{summary})
+	} else {
+		sort.Sort(summary)
+	}
+
+	// Fold everything past the top N languages into one row, so
+	// the percentages still add up.
+	if top > 0 {
+		var kept sortable
+		other := countRecord{Language: "(other)"}
+		shown := 0
+		for _, r := range summary {
+			if r.Language == "all" || shown < top {
+				if r.Language != "all" {
+					shown++
+				}
+				kept = append(kept, r)
+			} else {
+				other.mergeLines(r)
+				other.FileCount += r.FileCount
+			}
+		}
+		if other.FileCount > 0 {
+			kept = append(kept, other)
+		}
+		summary = kept
+	}
+
+	denominator := totals.LineCount
+	if splitTests && production > 0 {
+		denominator = production
+	} else if nonGenerated && handwritten > 0 {
+		denominator = handwritten
+	}
+	// Size the name and count columns to fit what's in them, so
+	// that long names like python-migrations don't push rows askew.
+	// Widths in fmt are measured in runes, as these are.
+	nameWidth, countWidth := 12, 7
+	for _, r := range summary {
+		if n := utf8.RuneCountInString(r.Language); n > nameWidth {
+			nameWidth = n
+		}
+		if n := len(showLines(r.LineCount)); n > countWidth {
+			countWidth = n
+		}
+	}
+	// The "all" row of -lang-stats is over every file.
+	var allFiles []SourceStat
+	if langStats && tmpl == nil && !jsonOut {
+		for _, r := range summary {
+			allFiles = append(allFiles, r.files...)
+		}
+		fmt.Fprintf(stdout, "%-*s %7s %*s %9s %9s %7s %7s\n",
+			nameWidth, "language", "files", countWidth, "total",
+			"mean", "median", "min", "max")
+	}
 	for i := range summary {
 		r := summary[i]
-		if json {
-			fmt.Printf("{\"language\":%q, \"linecount\":%d, \"filecount\":%d}\n",
-				r.language,
-				r.linecount,
-				r.filecount)
+		// The "all" row is its own whole, whatever the rows
+		// under it are reckoned against.
+		percent := float64(r.LineCount) * 100.0 / float64(denominator)
+		if r.Language == "all" {
+			percent = float64(r.LineCount) * 100.0 / float64(totals.LineCount)
+		}
+		if tmpl != nil {
+			err := tmpl.Execute(stdout,
+				formatContext{r, percent, totals})
+			if err != nil {
+				fatal(err)
+			}
+		} else if jsonOut {
+			var rec bytes.Buffer
+			fmt.Fprintf(&rec, "{\"language\":%q, \"linecount\":%d, \"filecount\":%d",
+				r.Language,
+				r.LineCount,
+				r.FileCount)
+			if splitData {
+				fmt.Fprintf(&rec, ", \"datacount\":%d", r.DataCount)
+			}
+			if countPreproc {
+				fmt.Fprintf(&rec, ", \"preproc\":%d", r.PreprocCount)
+			}
+			if allLines {
+				fmt.Fprintf(&rec, ", \"alllines\":%d", r.TotalLines)
+			}
+			if countEffective {
+				fmt.Fprintf(&rec, ", \"effective\":%d", r.Effective)
+			}
+			if countAPI {
+				fmt.Fprintf(&rec, ", \"api\":%d", r.API)
+			}
+			if countChars {
+				fmt.Fprintf(&rec, ", \"bytes\":%d, \"runes\":%d", r.Bytes, r.Runes)
+			}
+			if indenting {
+				fmt.Fprintf(&rec, ", \"meanindent\":%.2f", meanIndent(r.Indent, r.LineCount))
+			}
+			if commentDensity {
+				fmt.Fprintf(&rec, ", \"commentdensity\":%.2f", commentShare(r.Commented, r.LineCount))
+			}
+			if detailed && r.Language != "all" {
+				sort.Slice(r.files, func(i, j int) bool {
+					return r.files[i].Path < r.files[j].Path
+				})
+				fmt.Fprint(&rec, ", \"files\":[")
+				for j, f := range r.files {
+					if j > 0 {
+						fmt.Fprint(&rec, ", ")
+					}
+					fmt.Fprintf(&rec, "{\"path\":%s, \"linecount\":%d}",
+						jsonString(f.Path), f.SLOC)
+				}
+				fmt.Fprint(&rec, "]")
+			}
+			fmt.Fprint(&rec, "}")
+			if jsonPretty {
+				var indented bytes.Buffer
+				if json.Indent(&indented, rec.Bytes(), "", "  ") == nil {
+					rec = indented
+				}
+			}
+			fmt.Fprintf(stdout, "%s\n", rec.Bytes())
+		} else if langStats {
+			files := r.files
+			if r.Language == "all" {
+				files = allFiles
+			}
+			mean, median, least, most := fileSizes(files)
+			fmt.Fprintf(stdout, "%-*s %7s %*s %9.1f %9.1f %7s %7s\n",
+				nameWidth, r.Language,
+				showCount(uint64(len(files))),
+				countWidth, showLines(r.LineCount),
+				mean, median, showCount(least), showCount(most))
 		} else {
-			fmt.Printf("%-12s %7d (%2.2f%%) in %d files\n",
-				r.language,
-				r.linecount,
-				float64(r.linecount)*100.0/float64(totals.linecount),
-				r.filecount)
+			fmt.Fprintf(stdout, "%-*s %*s (%2.2f%%) in %s files",
+				nameWidth, r.Language,
+				countWidth, showLines(r.LineCount),
+				percent,
+				showCount(r.FileCount))
+			if splitData {
+				fmt.Fprintf(stdout, ", %s data lines", showLines(r.DataCount))
+			}
+			if countPreproc {
+				fmt.Fprintf(stdout, ", %s preprocessor lines", showLines(r.PreprocCount))
+			}
+			if allLines {
+				fmt.Fprintf(stdout, ", %s lines in all", showLines(r.TotalLines))
+			}
+			if countEffective {
+				fmt.Fprintf(stdout, ", %s effective lines", showLines(r.Effective))
+			}
+			if countAPI {
+				fmt.Fprintf(stdout, ", %s declaration lines", showLines(r.API))
+			}
+			if countChars {
+				fmt.Fprintf(stdout, ", %s bytes, %s characters", showCount(r.Bytes), showCount(r.Runes))
+			}
+			if indenting {
+				fmt.Fprintf(stdout, ", mean indent %.1f", meanIndent(r.Indent, r.LineCount))
+			}
+			if commentDensity {
+				fmt.Fprintf(stdout, ", comment density %.2f", commentShare(r.Commented, r.LineCount))
+			}
+			fmt.Fprint(stdout, "\n")
+		}
+	}
+
+	// Effort grows faster than size, so the estimates for the
+	// languages add up to less than the one for the whole.
+	if cocomoPerLang {
+		for _, r := range summary {
+			if r.Language != "all" {
+				reportCocomo(r.Language, r.LineCount)
+				fmt.Fprintln(stdout)
+			}
 		}
+		reportCocomo("all", totals.LineCount)
+	} else if cocomo {
+		reportCocomo("", totals.LineCount)
 	}
 
-	if cocomo {
-		reportCocomo(totals.linecount)
+	if duplicates != nil {
+		fmt.Fprintf(os.Stderr, "%d duplicate files collapsed\n",
+			duplicates.collapsed)
+	}
+	if generatedFiles.FileCount > 0 {
+		fmt.Fprintf(os.Stderr, "%d generated files (%d lines) not counted\n",
+			generatedFiles.FileCount, generatedFiles.LineCount)
 	}
 }
 
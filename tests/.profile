@@ -0,0 +1,4 @@
+# Should count 2 lines.
+# Read by login shells of the Bourne family.
+PATH="$HOME/bin:$PATH"
+export PATH
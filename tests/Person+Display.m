@@ -0,0 +1,12 @@
+// A property-based Objective-C category.  Should count 9 lines.
+#import "Person.h"
+
+@interface Person (Display)
+@property (nonatomic, readonly) NSString *displayName;
+@end
+
+@implementation Person (Display)
+- (NSString *)displayName {
+    return self.name.capitalizedString;
+}
+@end
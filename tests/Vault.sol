@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: MIT
+// A Solidity contract.  Should count 10 lines.
+pragma solidity ^0.8.20;
+
+/// @title A vault holding one owner's ether
+/// @notice NatSpec comments are comments like any other
+contract Vault {
+    address public owner;
+    string public motto = 'don\'t // panic';
+
+    /**
+     * @notice Withdraw everything to the owner
+     */
+    function withdraw() external {
+        require(msg.sender == owner, "not owner /* really */");
+        // Send the whole balance
+        payable(owner).transfer(address(this).balance);
+    }
+
+    receive() external payable {}
+}
@@ -0,0 +1,19 @@
+// Declaration lines: 5 of the 14 lines of code.
+package shapes;
+
+public class Shape {
+    private int sides;
+
+    public Shape(int sides) {
+        this.sides = sides;
+    }
+
+    public int getSides() {
+        helper(sides);
+        return sides;
+    }
+
+    interface Visitor {
+        void visit(Shape s);
+    }
+}
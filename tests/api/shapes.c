@@ -0,0 +1,25 @@
+/* Declaration lines: 6 of the 19 lines of code. */
+#include <stdio.h>
+
+struct point {
+	int x, y;
+};
+
+typedef struct point point_t;
+
+static int area(int width,
+		int height)
+{
+	int result = width * height;	/* not a declaration */
+	if (result < 0)
+		return 0;
+	return result;
+}
+
+int perimeter(int width, int height);
+
+struct point *origin(void)
+{
+	static struct point o;
+	return &o;
+}
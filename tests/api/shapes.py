@@ -0,0 +1,20 @@
+# Declaration lines: 4 of the 10 lines of code.
+import math
+
+
+class Circle:
+    """A circle, with a def in its docstring:
+    def nothing():
+    """
+
+    def __init__(self, r):
+        self.r = r
+
+    def area(self):
+        def square(x):
+            return x * x
+        return math.pi * square(self.r)
+
+
+def unit():
+    return Circle(1)
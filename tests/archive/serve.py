@@ -0,0 +1,25 @@
+#!/usr/bin/env python3
+# Serve this directory on a loopback port while running the command
+# given, with {} in its arguments standing for the server's URL, so
+# that make check can exercise -url.  A request for /redirect is sent
+# on to a file: URL, which loccount must refuse to follow.
+import functools, http.server, os, subprocess, sys, threading
+
+class Handler(http.server.SimpleHTTPRequestHandler):
+    def do_GET(self):
+        if self.path == "/redirect":
+            self.send_response(302)
+            self.send_header("Location", "file:///etc/passwd")
+            self.end_headers()
+        else:
+            super().do_GET()
+
+    def log_message(self, format, *args):
+        pass
+
+here = os.path.dirname(os.path.abspath(__file__))
+server = http.server.ThreadingHTTPServer(("127.0.0.1", 0),
+                                         functools.partial(Handler, directory=here))
+threading.Thread(target=server.serve_forever, daemon=True).start()
+url = "http://127.0.0.1:%d" % server.server_address[1]
+sys.exit(subprocess.call([arg.replace("{}", url) for arg in sys.argv[1:]]))
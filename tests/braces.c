@@ -0,0 +1,14 @@
+/* Lines holding only braces are counted unless -no-count-braces.
+   Should count 12 lines. */
+int sign(int x)
+{
+	if (x < 0)
+	{
+		return -1;
+	}
+	else
+	{
+		return x > 0;
+	}
+	;
+}
@@ -0,0 +1,26 @@
+# A Cucumber feature.  Should count 19 lines.
+Feature: Checkout
+  Customers pay for what is in their basket.
+
+  # Shared setup for every scenario
+  Background:
+    Given a customer with a basket
+
+  Scenario: Paying by card
+    When they pay with a valid card
+    Then the order is confirmed
+
+  Scenario Outline: Discounts
+    Given a basket worth <total>
+    Then the discount is <discount>
+
+    Examples:
+      | total | discount |
+      | 100   | 0        |
+      | 500   | 25       |
+
+  Scenario: Receipt
+    Then the receipt reads
+      """
+      # Order 42 -- not a comment
+      """
@@ -0,0 +1,19 @@
+/* Conditional compilation; # inside strings is not a directive.
+   Should count 15 lines. */
+#include <stdio.h>
+#define GREETING "#hello"
+#define TWICE(x) \
+	((x) + (x))
+
+#ifdef DEBUG
+static const char *mode = "debug #1";
+#else
+static const char *mode = "release";
+#endif
+
+int main(void)
+{
+	  # /* a null directive, indented */
+	printf("%s %s %d\n", GREETING, mode, TWICE(1));
+	return 0;
+}
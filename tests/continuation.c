@@ -0,0 +1,7 @@
+/* A winged comment ending in backslash continues onto the next line. */
+int main(void)
+{
+	// This comment is spliced to the next line \
+	return 1;
+	return 0;
+}
@@ -0,0 +1,21 @@
+// Should count 16 lines
+`include "defines.vh"
+`timescale 1ns / 1ps
+
+`ifdef SIMULATION
+`define WIDTH 8
+`else
+`define WIDTH 16
+`endif
+
+/* A simple counter.
+   Backticks in a comment: `ifdef */
+module counter(input clk, input rst, output reg [`WIDTH-1:0] count);
+  always @(posedge clk) begin
+    if (rst)
+      count <= 4'b0000;  // sized literal, then a comment
+    else
+      count <= count + 1'b1;
+    $display("it's %d", count);
+  end
+endmodule
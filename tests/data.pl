@@ -0,0 +1,10 @@
+#!/usr/bin/perl
+# Lines after __DATA__ are data, split out by -data.
+while (<DATA>) {
+    print;
+}
+__DATA__
+alpha # not a comment here
+beta
+
+gamma
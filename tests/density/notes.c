@@ -0,0 +1,10 @@
+/* Density fixture: 7 code lines, 3 of them carry a comment. */
+#include <stdio.h>
+
+int main(void)		/* entry */
+{
+	char *s = "// not a comment";
+	printf("%s\n", s);	// print it
+	return 0; /* done
+		     still a comment */
+}
@@ -0,0 +1,6 @@
+# Density fixture: 4 code lines, 1 of them carries a comment.
+import sys
+
+def main():
+    s = "not a comment"
+    print(s)  # print it
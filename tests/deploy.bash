@@ -0,0 +1,7 @@
+#!/usr/bin/env bash
+# Deploy a build.  Should count 4 lines.
+set -euo pipefail
+
+for host in "$@"; do
+    rsync -a build/ "$host:/srv/app/"
+done
@@ -0,0 +1,21 @@
+"""Module docstring.  Should count 6 lines,
+or 14 with -docstrings-as-code.
+"""
+
+import os
+
+
+def listing(path):
+    """Return the names in a directory, sorted."""
+    return sorted(os.listdir(path))
+
+
+class Walker:
+    '''Walk a tree.
+
+    Each visit yields a path.
+    '''
+
+    def __init__(self, root):
+        """Remember the root."""
+        self.root = root
@@ -0,0 +1,2 @@
+/* Generated automatically by stubgen. */
+int x;
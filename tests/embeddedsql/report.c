@@ -0,0 +1,13 @@
+/* A query in a C string, and a string that only mentions one. */
+#include <stdio.h>
+
+static const char *query =
+    "SELECT region, SUM(amount) "
+    "FROM sales GROUP BY region";
+
+int main(void)
+{
+    puts("selected region:");
+    puts(query);
+    return 0;
+}
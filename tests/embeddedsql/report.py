@@ -0,0 +1,22 @@
+# Queries in the shapes Python code usually writes them.
+import sqlite3
+
+def totals(db):
+    q = """
+SELECT region, SUM(amount)
+FROM sales
+GROUP BY region
+"""
+    return db.execute(q).fetchall()
+
+def recent(db, n):
+    return db.execute('select * from sales where id > ?', (n,)).fetchall()
+
+def purge(db):
+    db.execute("""DELETE FROM sales
+                  WHERE amount = 0""")
+
+GREETING = "selected items"  # not SQL
+NOTE = '''
+Selection happens elsewhere.
+'''
@@ -0,0 +1,14 @@
+// Code emitted by protoc-gen-go, named by its convention, whose
+// header the content scan doesn't know.  Should count 9 lines.
+package api
+
+type Pong struct {
+	state   int
+	unknown []byte
+}
+
+func (x *Pong) Reset() {
+	*x = Pong{}
+}
+
+func (*Pong) ProtoMessage() {}
@@ -0,0 +1,6 @@
+# Emitted by protoc --python_out.  Should count 4 lines.
+from google.protobuf import descriptor as _descriptor
+
+DESCRIPTOR = _descriptor.FileDescriptor(name='api.proto')
+_PONG = DESCRIPTOR.message_types_by_name['Pong']
+Pong = type('Pong', (), {})
@@ -0,0 +1,8 @@
+// Hand-written code using generated stubs.  Should count 5 lines.
+package service
+
+import pb "example.com/api"
+
+func Ping() *pb.Pong {
+	return &pb.Pong{}
+}
@@ -0,0 +1,15 @@
+// Package main serves a page.  Should count 9 lines; the
+// files it embeds are counted too, unless -skip-embedded.
+package main
+
+import (
+	_ "embed"
+	"fmt"
+)
+
+//go:embed schema.sql
+var schema string
+
+func main() {
+	fmt.Print(schema)
+}
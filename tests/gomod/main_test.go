@@ -0,0 +1,10 @@
+package main
+
+import "testing"
+
+// TestSchema only proves there is something to test.  Should count 7 lines.
+func TestSchema(t *testing.T) {
+	if schema == "" {
+		t.Fatal("no schema")
+	}
+}
@@ -0,0 +1,5 @@
+-- Embedded in main.go.  Should count 4 lines.
+CREATE TABLE page (
+    id INTEGER PRIMARY KEY,
+    body TEXT
+);
@@ -0,0 +1,6 @@
+# Input data for tests, skipped with -skip-testdata.
+# Should count 3 lines.
+def golden():
+    return [1, 2, 3]
+
+print(golden())
@@ -0,0 +1,4 @@
+# A fish function.  Should count 3 lines.
+function greet --argument name
+    echo "Hello, $name"  # trailing comment
+end
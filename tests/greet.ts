@@ -0,0 +1,12 @@
+// TypeScript, which the verifier tells from Qt Linguist XML.
+// Should count 8 lines.
+interface Greeter {
+  greet(name: string): string;
+}
+
+const polite: Greeter = {
+  greet: (name) => `Good day,
+${name}`,
+};
+
+console.log(polite.greet('world'));
@@ -0,0 +1,18 @@
+// Should count 14 lines
+#ifndef STACK_H
+#define STACK_H
+
+#include <vector>
+
+namespace tiny {
+template <typename T>
+class stack {
+public:
+    void push(const T &v) { items.push_back(v); }
+    T pop() { T v = items.back(); items.pop_back(); return v; }
+private:
+    std::vector<T> items;
+};
+}
+
+#endif
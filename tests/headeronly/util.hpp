@@ -0,0 +1,6 @@
+// Should count 3 lines
+#pragma once
+
+inline int twice(int x) { return 2 * x; }
+/* nothing else */
+inline int thrice(int x) { return 3 * x; }
@@ -0,0 +1,12 @@
+; Should count 8 lines
+; armasm-style: immediates carry # and comments start with ;
+
+        AREA    hello, CODE, READONLY
+        ENTRY
+start
+        MOV     r0, #5          ; immediate operand, not a comment
+        ADD     r1, r0, #0x10
+        CMP     r1, #21
+        BNE     start           ; loop back
+
+        END
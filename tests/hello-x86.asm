@@ -0,0 +1,15 @@
+; Should count 11 lines
+; NASM hello world for Linux x86-64
+
+section .data
+msg:    db "Hello; world", 10   ; the semicolon in the string is data
+len:    equ $ - msg
+
+section .text
+global _start
+_start:
+        mov rax, 1              ; write
+        mov rdi, 1
+        mov rsi, msg
+        mov rdx, len
+        syscall
@@ -0,0 +1,11 @@
+// Should count 6 lines, in Genie.
+[indent=4]
+
+/* Genie blocks are indented,
+   but comments are Vala's. */
+init
+    var text = """a // verbatim
+string"""
+    print text // trailing
+
+    print "done"
@@ -0,0 +1,10 @@
+; LLVM IR greeting.
+@.str = private constant [6 x i8] c"hello\00"
+
+declare i32 @puts(i8*)
+
+; The entry point.
+define i32 @main() {
+  %1 = call i32 @puts(i8* getelementptr ([6 x i8], [6 x i8]* @.str, i32 0, i32 0)) ; print it
+  ret i32 0
+}
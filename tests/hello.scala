@@ -0,0 +1,15 @@
+/* A Scala greeting.
+ * /* Block comments nest in Scala, so this inner
+ *    comment does not end the outer one. */
+ * Still in the outer comment.
+ */
+object Hello {
+  // A winged comment.
+  val banner = """Visit http://example.com // not a comment
+  /* nor is this */"""
+
+  def main(args: Array[String]): Unit = {
+    val who = "world"
+    println(s"Hello, $who!") /* trailing /* nested */ comment */
+  }
+}
@@ -0,0 +1,9 @@
+;; WebAssembly text format greeting.
+(; Block comments (; nest ;) in this
+   format, so this line is still comment. ;)
+(module
+  (import "env" "print" (func $print (param i32 i32)))
+  (memory 1)
+  (data (i32.const 0) "Hello ;; world") ;; trailing comment
+  (func (export "main")
+    (; inline ;) (call $print (i32.const 0) (i32.const 14))))
@@ -0,0 +1,20 @@
+// A React component.  Should count 15 lines.
+import React from 'react';
+
+/* Renders a link
+   with some help text */
+export default function Help({ url }) {
+  // a real comment
+  return (
+    <div className="help">
+      {/* a comment in an escape */}
+      <p>
+        Docs live at https://example.com/docs
+        /* Prices in USD */
+      </p>
+      <a href={url}>// not a comment either</a>
+      <br />
+      {url ? <span>{url}</span> : null}
+    </div>
+  );
+}
@@ -0,0 +1,14 @@
+# Ruby block comments, heredocs, and percent literals.  Should count 9 lines.
+=begin
+This is all comment,
+even the following # mark and "quote".
+=end
+greeting = "Hello # not a comment"
+words = %w[alpha #beta
+           gamma]
+query = <<~SQL
+  SELECT * FROM t # still heredoc
+  WHERE x = 1
+SQL
+puts %q(nested (parens) # inside)  # a real comment
+puts greeting, words, query
@@ -0,0 +1,10 @@
+/* Should count 8 lines.  Tabs and spaces are mixed on purpose. */
+int sum(int *v, int n)
+{
+	int total = 0;
+    int i;
+
+	for (i = 0; i < n; i++)
+	    total += v[i];
+  	return total;
+}
@@ -0,0 +1,7 @@
+# Should count 5 lines.  Tabs and spaces are mixed on purpose.
+def walk(tree):
+	for node in tree:
+	    if node:
+			# Indented comments are not code
+	        yield node
+    return
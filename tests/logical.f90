@@ -0,0 +1,9 @@
+! Free-form continuation and statement separators.
+program logic
+  integer :: i, j
+  i = 1 + &
+      2 + &
+      & 3
+  j = 4; i = i + j   ! two statements; one comment
+  print *, "a;b", i
+end program logic
@@ -0,0 +1,14 @@
+# Terraform configuration with both winged-comment styles.
+// This is a comment too.
+/* And a block
+   comment. */
+resource "aws_instance" "web" {
+  ami  = "ami-123456" # trailing comment
+  tags = {
+    Url = "http://example.com/#anchor"
+  }
+  user_data = <<-EOT
+    #!/bin/bash
+    echo "// not a comment"
+  EOT
+}
@@ -0,0 +1,21 @@
+# Julia, with nested block comments.  Should count 12 lines.
+#= A block comment
+   #= with another nested inside =#
+   still a comment here
+=#
+module Matrices
+
+"""
+    gram(A)
+
+Docstrings are triple-quoted strings, so they count.
+"""
+gram(A) = A' * A   # ' after a value is a transpose, not a quote
+
+function describe(x)
+    c = '#'            # a character literal, not a comment
+    s = "size $(length(x)) with a \"#\" in it"
+    return "$(join(["#=", "=#"], " ")) $s"
+end
+
+end #= an inline block =# # and a winged comment
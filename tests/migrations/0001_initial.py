@@ -0,0 +1,15 @@
+# A Django initial migration.  Should count 10 lines.
+from django.db import migrations, models
+
+
+class Migration(migrations.Migration):
+    initial = True
+
+    dependencies = []
+
+    operations = [
+        migrations.CreateModel(
+            name="Person",
+            fields=[("name", models.CharField(max_length=80))],
+        ),
+    ]
@@ -0,0 +1,8 @@
+-- A Flyway versioned migration.  Should count 5 lines.
+
+CREATE TABLE person (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(80) NOT NULL
+);
+-- Seed data follows
+INSERT INTO person (id, name) VALUES (1, 'Ada');
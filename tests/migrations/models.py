@@ -0,0 +1,9 @@
+# Not a migration, though it lives beside them.  Should count 6 lines.
+from django.db import models
+
+class Person(models.Model):
+    name = models.CharField(max_length=80)
+    email = models.EmailField(blank=True)
+
+    def __str__(self):
+        return self.name
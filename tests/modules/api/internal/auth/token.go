@@ -0,0 +1,6 @@
+// Should count 4 lines.
+package auth
+
+func Token() string {
+	return "t"
+}
@@ -0,0 +1,8 @@
+// Should count 6 lines.
+package com.example;
+
+public class App {
+    public static void main(String[] args) {
+        System.out.println("hi");
+    }
+}
@@ -0,0 +1,3 @@
+# Should count 2 lines.
+from shop.cart.total import total
+print(total([1, 2]))
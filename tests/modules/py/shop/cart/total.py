@@ -0,0 +1,3 @@
+# Should count 2 lines.
+def total(items):
+    return sum(items)
@@ -0,0 +1,4 @@
+// Should count 2 lines.
+package main
+
+func main() {}
@@ -0,0 +1,13 @@
+/-! A Lean module.  Should count 6 lines.
+  /- Block comments nest -/ so this is still a comment. -/
+namespace Nat'
+
+/-- Doubling, with a docstring. -/
+def double (n : Nat) : Nat := n + n  -- trailing comment
+
+theorem double_zero : double 0 = 0 := by
+  rfl
+
+def greeting : String := "not -- a comment"
+
+end Nat'
@@ -0,0 +1,16 @@
+// Should count 10 lines
+module Nested
+
+(* A block comment
+   (* with a nested one *)
+   still a comment *)
+let product = List.fold (*) 1 [1; 2; 3]
+
+let banner = """
+// not a comment
+(* nor this *)
+"""
+let path = @"C:\temp\"
+let quote = '"'
+let id (x: 'T) = x  // a type variable, then a comment
+let twice f = f >> f (* trailing comment *)
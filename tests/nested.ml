@@ -0,0 +1,9 @@
+(* OCaml comments nest: (* this is inner *) and this is still comment.
+   A string in a comment is lexed, so "*)" here doesn't end it.
+   Should count 4 lines. *)
+let greeting = "Hello (* not a comment *)"
+
+let quote = '"'
+
+let () =
+  (* print it *) print_endline greeting
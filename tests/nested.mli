@@ -0,0 +1,4 @@
+(* Interface for nested.ml (* nested *) *)
+val greeting : string
+
+val quote : char
@@ -0,0 +1,5 @@
+// Should count 3 lines
+package main
+
+func main() {
+}
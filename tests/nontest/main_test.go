@@ -0,0 +1,2 @@
+// Should count 1 line
+package main
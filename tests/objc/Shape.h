@@ -0,0 +1,11 @@
+// An Objective-C header; it goes with the .m files, not with the C.
+// Should count 7 lines.
+#import <Foundation/Foundation.h>
+
+@protocol Drawable
+- (void)draw;
+@end
+
+@interface Shape : NSObject <Drawable>
+@property (nonatomic) double area;
+@end
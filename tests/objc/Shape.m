@@ -0,0 +1,10 @@
+// Should count 8 lines.
+#import "Shape.h"
+#include "geometry.h"
+
+@implementation Shape
+- (void)draw {
+    NSLog(@"area %f", self.area);
+    self.area = square(self.area);
+}
+@end
@@ -0,0 +1,6 @@
+/* Should count 4 lines. */
+#include "geometry.h"
+
+double square(double x) {
+    return x * x;
+}
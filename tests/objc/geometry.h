@@ -0,0 +1,5 @@
+/* A plain C header, which folds into C.  Should count 4 lines. */
+#ifndef GEOMETRY_H
+#define GEOMETRY_H
+double square(double x);
+#endif
@@ -0,0 +1,10 @@
+extends Node2D
+"""
+Docstrings are comments, as in Python.
+"""
+
+# Movement speed.
+var speed = 200  # pixels per second
+
+func _process(delta):
+    position.x += speed * delta
@@ -0,0 +1,15 @@
+(* Addition on naturals.  Should count 10 lines.
+   (* Comments nest, so this line is still commentary *)
+   and so is this one. *)
+Require Import Arith.
+
+Fixpoint plus' (n m : nat) : nat :=
+  match n with
+  | O => m
+  | S n' => S (plus' n' m) (* a trailing (* nested *) remark *)
+  end.
+
+Theorem plus'_O : forall n, plus' n O = n.
+Proof.
+  induction n; simpl; auto.
+Qed.
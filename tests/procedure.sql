@@ -0,0 +1,13 @@
+-- Should count 9 lines: GO separates batches and isn't code.
+IF OBJECT_ID('dbo.Rename', 'P') IS NOT NULL
+    DROP PROCEDURE dbo.Rename;
+GO
+
+CREATE PROCEDURE [dbo].[Rename]
+    @Name nvarchar(50)
+AS
+BEGIN
+    SET NOCOUNT ON;
+    UPDATE dbo.People SET Name = 'O''Brien -- not a comment' WHERE Name = @Name; /* done */
+END
+go
@@ -0,0 +1,22 @@
+// Should count 17 lines
+package main
+
+import "fmt"
+
+var url = `http://example.com // not a comment`
+var open = `/* not a comment either`
+var tick = '`'
+var quote = "`"
+
+func main() {
+	s := fmt.Sprintf(
+		`%s
+// still a string
+	/*
+*/`, url) // a real comment
+	/* a real comment with ` in it */
+	banner :=
+		`
+// banner`
+	fmt.Println(s, open, tick, quote, banner)
+}
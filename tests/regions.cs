@@ -0,0 +1,20 @@
+// Folding markers are code unless -no-count-regions is given.
+// Should count 14 lines, or 10 without the markers.
+using System;
+
+namespace Folding
+{
+    class Shapes
+    {
+        #region Fields
+        private int sides = 4;
+        #endregion
+
+        #region Methods
+        public int Sides() { return sides; }
+        #endregion
+
+        /* #region in a comment is a comment either way */
+        private string label = "#region in a string is code";
+    }
+}
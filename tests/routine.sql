@@ -0,0 +1,11 @@
+-- Should count 6 lines: the DELIMITER commands aren't code.
+DELIMITER //
+CREATE PROCEDURE tally(IN n INT)
+BEGIN
+    /* A doubled quote doesn't end the string; -- nor does this. */
+    SELECT 'it''s -- not a comment', n;
+    UPDATE counters SET hits = hits + n;
+END //
+DELIMITER ;
+
+SELECT 'done';
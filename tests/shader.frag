@@ -0,0 +1,13 @@
+#version 330 core
+// Preprocessor directives are code, not comments.
+#ifdef GL_ES
+precision mediump float;
+#endif
+
+/* Output color. */
+out vec4 color;
+
+void main()
+{
+    color = vec4(1.0, 0.5, 0.2, 1.0);
+}
@@ -0,0 +1,3 @@
+#!/usr/bin/awk -f
+# An awk greeting.
+BEGIN { print "hello" }
@@ -0,0 +1,3 @@
+#!/usr/bin/env lua
+-- Lua comments are not hash comments.
+print("hello")
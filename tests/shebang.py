@@ -0,0 +1,3 @@
+#!/usr/bin/env python
+# A Python greeting.
+print("hello")
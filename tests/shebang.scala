@@ -0,0 +1,3 @@
+#!/usr/bin/env scala
+// A Scala script.
+println("hello")
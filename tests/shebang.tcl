@@ -0,0 +1,3 @@
+#!/usr/bin/tclsh
+# A Tcl greeting.
+puts "hello"
@@ -0,0 +1,10 @@
+/* With -strict, this fails the run: the last comment never closes. */
+int answer(void)
+{
+	return 42;
+}
+
+/* Forgotten, like the rest of this file
+int question(void)
+{
+}
@@ -0,0 +1,10 @@
+-- Should count 7 lines.
+-- A -- in a string or after a quote character is not a comment.
+with Ada.Text_IO; use Ada.Text_IO;
+procedure Strings is
+   Rule  : constant String := "a--b";  -- a comment follows
+   Quote : constant Character := '"';  -- not the start of a string
+begin
+   Put_Line ("He said ""--"" twice" & Quote & Integer'Image (Rule'Length));
+   -- Put_Line ("commented out");
+end Strings;
@@ -0,0 +1,4 @@
+;; Clojure string with a semicolon.
+(defn greet []
+  "see;saw")
+(println (greet))
@@ -0,0 +1,5 @@
+%% Erlang string with a percent sign.
+-module(strings).
+-export([pct/0]).
+pct() ->
+    "100%".
@@ -0,0 +1,5 @@
+; Semicolons inside strings are not comments.
+(defun greet ()
+  "hello;world")
+(format t "~a~%"
+  "a;b \"c;d\" e")
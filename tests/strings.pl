@@ -0,0 +1,4 @@
+% Prolog string with a percent sign.
+discount(X) :-
+    format("50%~n"),
+    X = "half".
@@ -0,0 +1,4 @@
+; Scheme string with a semicolon.
+(define greeting
+  "x;y")
+(display greeting) ; a real comment
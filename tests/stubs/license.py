@@ -0,0 +1,4 @@
+# Copyright (c) the authors.
+# SPDX-License-Identifier: BSD-2-Clause
+#
+# A license stub, comments only.  Should count 0 lines.
@@ -0,0 +1,2 @@
+/* Generated automatically by stubgen; it has code all the same. */
+int x;
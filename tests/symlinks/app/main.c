@@ -0,0 +1,6 @@
+/* Should count 5 lines. */
+int generated(int);
+int main(void)
+{
+	return generated(41);
+}
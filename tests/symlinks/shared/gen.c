@@ -0,0 +1,5 @@
+/* Should count 4 lines, once however many links lead here. */
+int generated(int x)
+{
+	return x + 1;
+}
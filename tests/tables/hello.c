@@ -0,0 +1,5 @@
+/* C, which only the default tables know */
+int main(void)
+{
+	return 0;
+}
@@ -0,0 +1,10 @@
+// Should count 9 lines: the verbatim string hides its comment markers.
+void main () {
+    string url = """http://example.org/  // not a comment
+/* nor this */
+""";
+    int count = 3; // trailing comment
+    string greeting = @"Visited $url $(count) times";
+    print (greeting);
+    print ("done\n");
+}
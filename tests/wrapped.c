@@ -0,0 +1,21 @@
+/* Should count 18 lines */
+#include <stdio.h>
+#define SUM(a, b) \
+	((a) + (b))
+
+int main(void)
+{
+	int total = SUM(
+		1,
+		2);
+
+	printf("%s %d %d %d\n",
+	       "wrapped",
+	       total,
+	       SUM(3,
+		   4),
+	       5);
+	if (total > 2)
+		puts("big");
+	return 0;
+}